@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Meha555/go-pipeline/history"
+	"github.com/Meha555/go-pipeline/pipeline"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd 列出本地保存的执行记录
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List recorded runs",
+	Long:  "List the runs recorded in the local state directory, newest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		records, err := history.NewStore(stateDir).Load()
+		if err != nil {
+			return err
+		}
+		if historyFile != "" {
+			configPath, err := filepath.Abs(historyFile)
+			if err != nil {
+				return err
+			}
+			records = slices.DeleteFunc(records, func(rec *history.Record) bool { return rec.Config != configPath })
+		}
+		slices.Reverse(records)
+		if historyLimit > 0 && len(records) > historyLimit {
+			records = records[:historyLimit]
+		}
+		writeRunList(cmd.OutOrStdout(), records)
+		return nil
+	},
+}
+
+// historyShowCmd 显示一次执行的详细情况
+var historyShowCmd = &cobra.Command{
+	Use:   "show <run-id>",
+	Short: "Show a recorded run",
+	Long:  "Show the status, timings, log files and exports of every stage and job of a recorded run. The run id may be a unique prefix",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rec, err := history.NewStore(stateDir).Get(args[0])
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		if historyJSON {
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(rec)
+		}
+		writeRunDetail(out, rec)
+		return nil
+	},
+}
+
+// historyPruneCmd 按时间或者数量删除旧的执行记录
+var historyPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old recorded runs",
+	Long:  "Delete the recorded runs older than --older-than, or all but the newest --keep runs",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pruneOlderThan <= 0 && pruneKeep <= 0 {
+			return errors.New("at least one of --older-than and --keep is required")
+		}
+		var before time.Time
+		if pruneOlderThan > 0 {
+			before = time.Now().Add(-pruneOlderThan)
+		}
+		pruned, err := history.NewStore(stateDir).Prune(before, pruneKeep)
+		fmt.Fprintf(cmd.OutOrStdout(), "pruned %d runs\n", len(pruned))
+		return err
+	},
+}
+
+// writeRunList 每行输出一次执行的概要
+func writeRunList(w io.Writer, records []*history.Record) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTATUS\tSTARTED\tDURATION\tPIPELINE\tCONFIG")
+	for _, rec := range records {
+		r := rec.Result
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s@%s\t%s\n", r.RunID, r.Status, formatTime(r.StartTime), formatDuration(r.Duration), r.Name, r.Version, rec.Config)
+	}
+	tw.Flush()
+}
+
+// writeRunDetail 输出一次执行的记录以及每个Stage、Job的状态、耗时、日志文件和导出的变量
+func writeRunDetail(w io.Writer, rec *history.Record) {
+	r := rec.Result
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Run:\t%s\n", r.RunID)
+	fmt.Fprintf(tw, "Pipeline:\t%s@%s\n", r.Name, r.Version)
+	fmt.Fprintf(tw, "Config:\t%s\n", rec.Config)
+	if rec.ConfigHash != "" {
+		fmt.Fprintf(tw, "Config hash:\t%s\n", rec.ConfigHash)
+	}
+	if len(rec.Args) > 0 {
+		fmt.Fprintf(tw, "Args:\t%s\n", strings.Join(rec.Args, " "))
+	}
+	if rec.Cron != "" {
+		fmt.Fprintf(tw, "Cron:\t%s\n", rec.Cron)
+	}
+	fmt.Fprintf(tw, "Status:\t%s\n", r.Status)
+	fmt.Fprintf(tw, "Started:\t%s\n", formatTime(r.StartTime))
+	fmt.Fprintf(tw, "Duration:\t%s\n", formatDuration(r.Duration))
+	if r.Error != "" {
+		fmt.Fprintf(tw, "Error:\t%s\n", r.Error)
+	}
+	tw.Flush()
+
+	for _, stage := range r.Stages {
+		fmt.Fprintf(w, "\nstage %s: %s (%s)\n", stage.Name, stage.Status, formatDuration(stage.Duration))
+		if stage.Error != "" {
+			fmt.Fprintf(w, "  error: %s\n", stage.Error)
+		}
+		for _, job := range stage.Jobs {
+			fmt.Fprintf(w, "  job %s: %s (%s)\n", job.Name, job.Status, formatDuration(job.Duration))
+			if job.RestoredFrom != "" {
+				fmt.Fprintf(w, "    restored from run %s\n", job.RestoredFrom)
+			}
+			if job.LogFile != "" {
+				fmt.Fprintf(w, "    log: %s\n", job.LogFile)
+			}
+			for _, action := range job.Actions {
+				if action.Status == pipeline.Success || action.Status == pipeline.Skiped {
+					continue
+				}
+				fmt.Fprintf(w, "    action %q: %s, exit code %d\n", action.Command, action.Status, action.ExitCode)
+				if action.Error != "" {
+					fmt.Fprintf(w, "      error: %s\n", action.Error)
+				}
+			}
+			for _, key := range slices.Sorted(maps.Keys(job.Exports)) {
+				fmt.Fprintf(w, "    export %s=%s\n", key, job.Exports[key])
+			}
+		}
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+var (
+	historyFile    string
+	historyLimit   int
+	historyJSON    bool
+	pruneOlderThan time.Duration
+	pruneKeep      int
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd, historyPruneCmd)
+
+	historyCmd.Flags().StringVarP(&historyFile, "file", "f", "", "only list the runs of this config file")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "maximum number of runs to list (0 means all)")
+	historyShowCmd.Flags().BoolVar(&historyJSON, "json", false, "print the raw record as JSON")
+	historyPruneCmd.Flags().DurationVar(&pruneOlderThan, "older-than", 0, "delete the runs started longer ago than this, e.g. 720h")
+	historyPruneCmd.Flags().IntVar(&pruneKeep, "keep", 0, "keep only this many of the newest runs")
+}
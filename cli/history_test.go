@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Meha555/go-pipeline/history"
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+func TestWriteRunDetailShowsStatusTreeAndExports(t *testing.T) {
+	rec := &history.Record{
+		Config:     "/work/pipeline.yml",
+		ConfigHash: "abc123",
+		Args:       []string{"run", "-f", "pipeline.yml"},
+		Result: &pipeline.RunResult{
+			Name: "demo", Version: "1.0.0", RunID: "20240501100000-aaaa", Status: pipeline.Failed,
+			Duration: 1500 * time.Millisecond,
+			Stages: []*pipeline.StageResult{{
+				Name: "build", Status: pipeline.Failed,
+				Jobs: []*pipeline.JobResult{
+					{Name: "build_job", Status: pipeline.Success, Exports: map[string]string{"ARTIFACT": "app.tar.gz"}, RestoredFrom: "20240430090000-zzzz", LogFile: "/state/logs/20240430090000-zzzz/build_job.log"},
+					{Name: "test_job", Status: pipeline.Failed, Actions: []*pipeline.ActionResult{
+						{Command: "make test", Status: pipeline.Failed, ExitCode: 2, Error: "exit status 2"},
+					}},
+				},
+			}},
+		},
+	}
+	var buf bytes.Buffer
+	writeRunDetail(&buf, rec)
+	got := buf.String()
+	for _, want := range []string{
+		"Run:         20240501100000-aaaa",
+		"Args:        run -f pipeline.yml",
+		"Duration:    1.5s",
+		"stage build: Failed",
+		"job build_job: Success",
+		"restored from run 20240430090000-zzzz",
+		"log: /state/logs/20240430090000-zzzz/build_job.log",
+		"export ARTIFACT=app.tar.gz",
+		`action "make test": Failed, exit code 2`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("detail missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Meha555/go-pipeline/history"
+	"github.com/Meha555/go-pipeline/pipeline"
+	"github.com/spf13/cobra"
+)
+
+// logsCmd 查看一次执行中Job的日志
+var logsCmd = &cobra.Command{
+	Use:   "logs <run-id> [job]",
+	Short: "Show the logs of a run",
+	Long:  "Show the output of every job of a run, or of one job. The run id may be a unique prefix, and the run may still be in progress",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := history.NewStore(stateDir)
+		runID, err := store.ResolveRunID(args[0])
+		if err != nil {
+			return err
+		}
+		// 仍在执行的流水线还没有记录，此时按照约定的路径查找日志
+		rec, err := store.Get(runID)
+		if err != nil && !errors.Is(err, history.ErrNotFound) {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		if len(args) == 1 {
+			if logsFollow {
+				return errors.New("--follow needs a job")
+			}
+			return writeRunLogs(out, store, runID, rec)
+		}
+
+		path := pipeline.JobLogPath(store.LogDir(), runID, args[1])
+		if rec != nil {
+			job := findJob(rec.Result, args[1])
+			if job == nil {
+				return fmt.Errorf("run %s has no job %s", runID, args[1])
+			}
+			if job.LogFile == "" {
+				return fmt.Errorf("job %s of run %s has no log", args[1], runID)
+			}
+			path = job.LogFile
+		}
+		if !logsFollow {
+			return copyFile(out, path)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return followLog(ctx, out, path, func() bool {
+			_, err := store.Get(runID)
+			return err == nil
+		})
+	},
+}
+
+// writeRunLogs 依次输出一次执行中每个Job的日志。有记录时按照执行顺序，否则按照文件名
+func writeRunLogs(w io.Writer, store *history.Store, runID string, rec *history.Record) error {
+	type jobLog struct{ name, path string }
+	var logs []jobLog
+	if rec != nil {
+		for _, stage := range rec.Result.Stages {
+			for _, job := range stage.Jobs {
+				if job.LogFile != "" {
+					logs = append(logs, jobLog{job.Name, job.LogFile})
+				}
+			}
+		}
+	} else {
+		paths, err := filepath.Glob(filepath.Join(store.LogDir(), runID, "*.log"))
+		if err != nil {
+			return err
+		}
+		slices.Sort(paths)
+		for _, path := range paths {
+			logs = append(logs, jobLog{strings.TrimSuffix(filepath.Base(path), ".log"), path})
+		}
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("run %s has no logs", runID)
+	}
+	for i, log := range logs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "==> %s (%s) <==\n", log.name, log.path)
+		if err := copyFile(w, log.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findJob(result *pipeline.RunResult, name string) *pipeline.JobResult {
+	for _, stage := range result.Stages {
+		for _, job := range stage.Jobs {
+			if job.Name == name {
+				return job
+			}
+		}
+	}
+	return nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// logsPollInterval 跟随日志时检查新输出的间隔
+const logsPollInterval = 200 * time.Millisecond
+
+// followLog 输出日志文件并持续输出新写入的内容，直到done返回true（执行已经结束）后输出完剩余内容，或者ctx被取消。
+// 日志文件还不存在时（Job还在等待执行）一直等待它被创建
+func followLog(ctx context.Context, w io.Writer, path string, done func() bool) error {
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for {
+		// 先判断是否结束再读取，保证结束前写入的内容都被输出
+		finished := done()
+		if file == nil {
+			var err error
+			if file, err = os.Open(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if file != nil {
+			if _, err := io.Copy(w, file); err != nil {
+				return err
+			}
+		}
+		if finished {
+			if file == nil {
+				return fmt.Errorf("no log at %s", path)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logsPollInterval):
+		}
+	}
+}
+
+var logsFollow bool
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "keep printing the job's new output until the run finishes")
+}
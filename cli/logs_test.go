@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFollowLogWaitsForFileAndStopsWhenRunFinishes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	polls := 0
+	var buf bytes.Buffer
+	err := followLog(context.Background(), &buf, path, func() bool {
+		polls++
+		switch polls {
+		case 2:
+			// Job开始执行后才创建日志文件
+			os.WriteFile(path, []byte("first\n"), 0o644)
+		case 3:
+			file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			file.WriteString("second\n")
+			file.Close()
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatalf("followLog() error = %v", err)
+	}
+	if got := buf.String(); got != "first\nsecond\n" {
+		t.Fatalf("followLog() output = %q", got)
+	}
+}
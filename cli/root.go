@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"io"
 	"os"
 
@@ -22,11 +23,37 @@ var (
 	logFormat     string
 	logLevel      string
 	logColor      string
+	stateDir      string
 	loggingWriter io.Writer = os.Stderr
 )
 
+const (
+	// 被Ctrl-C等信号中断时使用的退出码，与shell的约定一致（128+SIGINT）
+	exitCodeCanceled = 130
+	// 流水线超时时使用的退出码，与coreutils的timeout命令一致
+	exitCodeTimedOut = 124
+)
+
+// exitError 携带进程退出码的错误，用于区分不同的失败原因
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -54,4 +81,5 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatConsole, "log format: {console|json}")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", logging.LevelInfo, "log level: {debug|info|warn|error|disabled}")
 	rootCmd.PersistentFlags().StringVar(&logColor, "log-color", logging.ColorAuto, "log color: {auto|never}")
+	rootCmd.PersistentFlags().StringVar(&stateDir, "state-dir", "", "directory to keep run records in (default: $PIPELINE_STATE_DIR or go-pipeline in the user cache directory)")
 }
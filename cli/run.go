@@ -3,13 +3,21 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/mail"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/Meha555/go-pipeline/history"
 	"github.com/Meha555/go-pipeline/internal"
 	"github.com/Meha555/go-pipeline/notify/email"
 	"github.com/Meha555/go-pipeline/parser"
 	"github.com/Meha555/go-pipeline/pipeline"
+	"github.com/Meha555/go-pipeline/report"
 
 	"github.com/spf13/cobra"
 )
@@ -27,13 +35,78 @@ var runCmd = &cobra.Command{
 			return fmt.Errorf("parsing %s failed: %w", configFile, err)
 		}
 
-		pipe := pipeline.MakePipeline(conf)
+		if reportFile != "" && reportFormat == "" {
+			reportFormat = report.FormatFromPath(reportFile)
+		}
+		if reportFile != "" {
+			// 提前检查报告格式，避免流水线执行完毕后才发现无法输出报告
+			if err = report.Write(io.Discard, reportFormat, &pipeline.RunResult{}); err != nil {
+				return err
+			}
+		}
+
+		// -v 相当于 --output=live
+		if output == "" {
+			output = outputNone
+			if verbose {
+				output = outputLive
+			}
+		}
+		if output != outputNone && output != outputLive && output != outputOnFailure {
+			return fmt.Errorf("invalid output %q: must be one of %s, %s, %s", output, outputNone, outputLive, outputOnFailure)
+		}
+
+		tagFilter, err := pipeline.ParseTagFilter(tags, excludeTags)
+		if err != nil {
+			return err
+		}
+		// 执行记录按照配置文件的绝对路径区分，避免在不同目录下执行时找错记录
+		configPath, err := filepath.Abs(configFile)
+		if err != nil {
+			return err
+		}
+		store := history.NewStore(stateDir)
+		pipeOpts := []pipeline.PipelineOptions{pipeline.WithTagFilter(tagFilter)}
+		if !dryRun {
+			pipeOpts = append(pipeOpts, pipeline.WithJobLogs(store.LogDir(), logMaxSize<<20), pipeline.WithResultHandler(func(result *pipeline.RunResult) {
+				recordRun(store, configPath, conf.Cron, result)
+			}))
+		}
+		pipe, err := pipeline.MakePipeline(conf, pipeOpts...)
+		if err != nil {
+			return fmt.Errorf("creating pipeline from %s failed: %w", configFile, err)
+		}
+		if err = pipe.Select(selection); err != nil {
+			return fmt.Errorf("selecting jobs to run failed: %w", err)
+		}
+		if rerunFailed {
+			if pipe.Cron != "" {
+				return fmt.Errorf("--rerun-failed cannot be used with cron pipeline %s", pipe.Name)
+			}
+			var last *history.Record
+			if last, err = store.Last(configPath); err != nil {
+				return fmt.Errorf("finding the last run to rerun failed: %w", err)
+			}
+			if err = checkConfigUnchanged(configPath, last, force); err != nil {
+				return err
+			}
+			rerun := pipe.Rerun(last.Result)
+			if rerun == 0 {
+				slog.Info(fmt.Sprintf("all jobs of run %s succeeded, nothing to rerun", last.Result.RunID), "run_id", last.Result.RunID)
+				return nil
+			}
+			slog.Info(fmt.Sprintf("rerunning %d jobs that did not succeed in run %s", rerun, last.Result.RunID), "run_id", last.Result.RunID, "jobs", rerun)
+		}
 
-		ctx := context.Background()
+		ctx, stop := withInterrupt(context.Background())
+		defer stop()
 		// 处理额外的参数
 		parser.ParseArgs(args, ctx)
-		if verbose {
-			ctx = context.WithValue(ctx, internal.VerboseKey, verbose)
+		switch output {
+		case outputLive:
+			ctx = context.WithValue(ctx, internal.VerboseKey, true)
+		case outputOnFailure:
+			ctx = context.WithValue(ctx, internal.OutputOnFailureKey, true)
 		}
 		if noSilence {
 			ctx = context.WithValue(ctx, internal.NoSilenceKey, noSilence)
@@ -44,8 +117,19 @@ var runCmd = &cobra.Command{
 		if dryRun {
 			ctx = context.WithValue(ctx, internal.DryRunKey, dryRun)
 		}
+		if maxJobs > 0 {
+			ctx = context.WithValue(ctx, internal.JobsKey, maxJobs)
+		}
 
-		status := pipe.Run(ctx)
+		result := pipe.Execute(ctx)
+		status := result.Status
+
+		// 无论执行结果如何都输出报告，失败时的报告才是最有价值的
+		if reportFile != "" {
+			if e := report.WriteFile(reportFile, reportFormat, result); e != nil {
+				slog.Error(fmt.Sprintf("write report failed: %v", e), "error", e, "report", reportFile)
+			}
+		}
 
 		// Notify 结果
 		var eNotifier *email.Sender
@@ -73,27 +157,51 @@ var runCmd = &cobra.Command{
 			}
 		}
 
-		if status == pipeline.Failed {
+		// 不同的状态使用不同的通知标题和退出码，便于区分超时、取消和真正的失败
+		var subject string
+		body := fmt.Sprintf("pipeline %s@%s run success", pipe.Name, pipe.Version)
+		switch status {
+		case pipeline.Failed:
+			subject = "Pipeline Failed"
 			err = fmt.Errorf("pipeline %s@%s run failed", pipe.Name, pipe.Version)
-			if conf.Notifiers != nil {
-				if conf.Notifiers.Email != nil {
-					if e := eNotifier.Send(ebuilder.
-						Subject("Pipeline Failed").
-						Body([]byte(err.Error())).
-						Build()); e != nil {
-						fmt.Printf("notifiying failed: %v", e)
-					}
-				}
+			if jobs := result.JobsWithStatus(pipeline.Failed); len(jobs) > 0 {
+				err = fmt.Errorf("%w: failed jobs: %s", err, strings.Join(jobs, ", "))
+			}
+		case pipeline.Canceled:
+			subject = "Pipeline Canceled"
+			err = &exitError{code: exitCodeCanceled, err: fmt.Errorf("pipeline %s@%s run canceled", pipe.Name, pipe.Version)}
+		case pipeline.TimedOut:
+			subject = "Pipeline Timed Out"
+			timeoutErr := fmt.Errorf("pipeline %s@%s run timed out", pipe.Name, pipe.Version)
+			if result.Error != "" {
+				timeoutErr = fmt.Errorf("%w: %s", timeoutErr, result.Error)
+			} else if jobs := result.JobsWithStatus(pipeline.TimedOut); len(jobs) > 0 {
+				timeoutErr = fmt.Errorf("%w: timed out jobs: %s", timeoutErr, strings.Join(jobs, ", "))
 			}
-		} else {
-			if conf.Notifiers != nil {
-				if conf.Notifiers.Email != nil {
-					if e := eNotifier.Send(ebuilder.
-						Subject("Pipeline Success").
-						Body(fmt.Appendf([]byte("pipeline %s@%s run success"), pipe.Name, pipe.Version)).
-						Build()); e != nil {
-						fmt.Printf("notifiying failed: %v", e)
-					}
+			err = &exitError{code: exitCodeTimedOut, err: timeoutErr}
+		case pipeline.AllowedFailure:
+			subject = "Pipeline Passed With Allowed Failures"
+			jobs := result.JobsWithStatus(pipeline.AllowedFailure)
+			body = fmt.Sprintf("pipeline %s@%s run succeeded with allowed failures: %s", pipe.Name, pipe.Version, strings.Join(jobs, ", "))
+			slog.Warn(body, "jobs", jobs)
+		case pipeline.Skiped:
+			// cron流水线在第一次触发之前就被中断
+			subject = "Pipeline Skipped"
+			body = fmt.Sprintf("pipeline %s@%s did not run", pipe.Name, pipe.Version)
+			slog.Warn(body)
+		default:
+			subject = "Pipeline Success"
+		}
+		if err != nil {
+			body = err.Error()
+		}
+		if conf.Notifiers != nil {
+			if conf.Notifiers.Email != nil {
+				if e := eNotifier.Send(ebuilder.
+					Subject(subject).
+					Body([]byte(body)).
+					Build()); e != nil {
+					fmt.Printf("notifiying failed: %v", e)
 				}
 			}
 		}
@@ -101,21 +209,119 @@ var runCmd = &cobra.Command{
 	},
 }
 
+// Action输出的显示方式，不论哪种方式输出都会保存到Job的日志文件中
+const (
+	outputNone      = "none"       // 不显示
+	outputLive      = "live"       // 执行时实时显示
+	outputOnFailure = "on-failure" // Job失败后显示其完整的日志
+)
+
+// recordRun 将一次执行的结果保存到执行记录中，供 history 命令和 --rerun-failed 使用。
+// 记录失败不影响执行的结果
+func recordRun(store *history.Store, configPath, cron string, result *pipeline.RunResult) {
+	rec := &history.Record{Config: configPath, Args: os.Args[1:], Cron: cron, Result: result}
+	var err error
+	if rec.ConfigHash, err = history.HashFile(configPath); err != nil {
+		slog.Warn(fmt.Sprintf("hash config file failed: %v", err), "error", err, "config", configPath)
+	}
+	if err = store.Save(rec); err != nil {
+		slog.Error(fmt.Sprintf("save run record failed: %v", err), "error", err, "state_dir", store.Dir)
+		return
+	}
+	slog.Debug(fmt.Sprintf("run %s recorded in %s", result.RunID, store.Dir), "run_id", result.RunID, "state_dir", store.Dir)
+}
+
+// checkConfigUnchanged 检查配置文件在上次执行之后是否被修改。
+// 修改后的配置中Job的命令或者导出的变量可能已经不同，恢复上次的结果并不可靠，因此除非force，否则拒绝重新执行
+func checkConfigUnchanged(configPath string, last *history.Record, force bool) error {
+	hash, err := history.HashFile(configPath)
+	if err != nil {
+		return err
+	}
+	if last.ConfigHash == hash {
+		return nil
+	}
+	if last.ConfigHash == "" {
+		slog.Warn(fmt.Sprintf("run %s has no config hash, cannot tell whether %s changed since", last.Result.RunID, configPath), "run_id", last.Result.RunID, "config", configPath)
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("%s changed since run %s, use --force to rerun its failed jobs anyway", configPath, last.Result.RunID)
+	}
+	slog.Warn(fmt.Sprintf("%s changed since run %s, rerunning its failed jobs anyway", configPath, last.Result.RunID), "run_id", last.Result.RunID, "config", configPath)
+	return nil
+}
+
+// withInterrupt 返回一个在收到SIGINT/SIGTERM时被取消的ctx。
+// Action运行在独立的进程组中，收不到终端的Ctrl-C，因此通过取消ctx来停止它们，并让流水线执行after hooks、输出统计信息；
+// 再次收到信号时不再等待，直接退出。
+func withInterrupt(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigChan:
+			slog.Warn(fmt.Sprintf("received %s, canceling pipeline (press Ctrl-C again to force quit)", sig), "signal", sig.String())
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-sigChan:
+			slog.Error(fmt.Sprintf("received %s again, force quit", sig), "signal", sig.String())
+			os.Exit(exitCodeCanceled)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigChan)
+		close(done)
+		cancel()
+	}
+}
+
 var (
-	configFile string
-	verbose    bool
-	noSilence  bool
-	trace      bool
-	dryRun     bool
+	configFile  string
+	verbose     bool
+	noSilence   bool
+	trace       bool
+	dryRun      bool
+	maxJobs     int
+	selection   pipeline.Selection
+	tags        string
+	excludeTags string
+	rerunFailed bool
+	force       bool
+	output      string
+	logMaxSize  int64
+
+	reportFile   string
+	reportFormat string
 )
 
 func init() {
 	rootCmd.AddCommand(runCmd)
 
 	runCmd.Flags().BoolVarP(&noSilence, "no-silence", "s", false, "print every action")
-	runCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output for jobs")
+	runCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose output for jobs, same as --output=live")
+	runCmd.Flags().StringVar(&output, "output", "", "when to print the output of actions: {none|live|on-failure} (default: none, or live with --verbose)")
+	runCmd.Flags().Int64Var(&logMaxSize, "log-max-size", pipeline.DefaultLogMaxSize>>20, "maximum size in MiB of each job log file (0 means unlimited)")
 	runCmd.Flags().BoolVarP(&trace, "trace", "t", false, "time trace for jobs")
 	runCmd.Flags().BoolVar(&dryRun, "dry-run", false, "dry run")
+	runCmd.Flags().IntVarP(&maxJobs, "jobs", "j", 0, "maximum total weight of jobs running at the same time (0 means unlimited)")
+	runCmd.Flags().StringSliceVar(&selection.Only, "only", nil, "only run the stages or jobs matching these names or glob patterns")
+	runCmd.Flags().StringSliceVar(&selection.Skip, "skip", nil, "skip the stages or jobs matching these names or glob patterns")
+	runCmd.Flags().StringVar(&selection.FromStage, "from-stage", "", "start from this stage")
+	runCmd.Flags().StringVar(&selection.UntilStage, "until-stage", "", "stop after this stage")
+	runCmd.Flags().BoolVar(&selection.WithNeeds, "with-needs", false, "also run the jobs that selected jobs need, directly or indirectly")
+	runCmd.Flags().StringVar(&tags, "tags", "", "only run the jobs whose tags match this expression, e.g. 'quick,lint' or 'unit && !windows'")
+	runCmd.Flags().StringVar(&excludeTags, "exclude-tags", "", "do not run the jobs whose tags match this expression")
+	runCmd.Flags().BoolVar(&rerunFailed, "rerun-failed", false, "only rerun the jobs that did not succeed in the last recorded run of the same config, restoring the exports of the others")
+	runCmd.Flags().BoolVar(&force, "force", false, "with --rerun-failed, rerun even if the config file changed since the last run")
+	runCmd.Flags().StringVar(&reportFile, "report", "", "write a run report to the file")
+	runCmd.Flags().StringVar(&reportFormat, "report-format", "", "report format: json, junit or markdown (default: inferred from the report file extension)")
 	runCmd.Flags().StringVarP(&configFile, "file", "f", "", "config file")
 	runCmd.MarkFlagRequired("file")
 }
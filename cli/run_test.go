@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Meha555/go-pipeline/history"
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+func TestCheckConfigUnchangedRefusesChangedConfigWithoutForce(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "pipeline.yml")
+	if err := os.WriteFile(configPath, []byte("name: demo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := history.HashFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := &history.Record{Config: configPath, ConfigHash: hash, Result: &pipeline.RunResult{RunID: "20240501100000-aaaa"}}
+	if err := checkConfigUnchanged(configPath, last, false); err != nil {
+		t.Fatalf("checkConfigUnchanged() error = %v, want nil for an unchanged config", err)
+	}
+
+	if err := os.WriteFile(configPath, []byte("name: demo\nfail_fast: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err = checkConfigUnchanged(configPath, last, false)
+	if err == nil || !strings.Contains(err.Error(), "changed since run 20240501100000-aaaa, use --force") {
+		t.Fatalf("checkConfigUnchanged() error = %v, want the config changed error", err)
+	}
+	if err := checkConfigUnchanged(configPath, last, true); err != nil {
+		t.Fatalf("checkConfigUnchanged(force) error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/Meha555/go-pipeline/parser"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd 校验配置文件，一次性报告其中的全部问题
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate a pipeline config file",
+	Long:  "Validate a pipeline config file and its includes, reporting every problem with its file, line and column",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, problems, err := parser.ValidateConfigFile(validateFile)
+		if err != nil {
+			return fmt.Errorf("loading %s failed: %w", validateFile, err)
+		}
+		out := cmd.OutOrStdout()
+		if len(problems) == 0 {
+			fmt.Fprintf(out, "%s is valid\n", validateFile)
+			return nil
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(out, problem)
+		}
+		return fmt.Errorf("%s has %d problem(s)", validateFile, len(problems))
+	},
+}
+
+var validateFile string
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&validateFile, "file", "f", "", "config file")
+	validateCmd.MarkFlagRequired("file")
+}
@@ -0,0 +1,233 @@
+// Package history 在本地状态目录中保存流水线的执行记录，用于查看历史执行情况，以及根据上一次的执行结果重新执行失败的Job
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+// ErrNotFound 没有找到符合条件的执行记录
+var ErrNotFound = errors.New("no recorded run found")
+
+// Record 一次流水线执行的记录
+type Record struct {
+	Config     string              `json:"config"`                // 配置文件的绝对路径，用于找到同一配置的执行记录
+	ConfigHash string              `json:"config_hash,omitempty"` // 执行时配置文件内容的sha256，用于判断配置是否被修改过
+	Args       []string            `json:"args,omitempty"`        // 执行时的命令行参数
+	Cron       string              `json:"cron,omitempty"`        // cron模式下的执行计划
+	Result     *pipeline.RunResult `json:"result"`                // 包括每个Stage、Job、Action的状态、耗时以及导出的变量
+}
+
+// HashFile 返回文件内容的sha256
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Store 本地状态目录，每次执行的记录保存为 runs/<RunID>.json，每个Job的日志保存在 logs/<RunID>/ 中
+type Store struct {
+	Dir string
+}
+
+// DefaultDir 返回默认的状态目录：环境变量 PIPELINE_STATE_DIR，未设置时为用户缓存目录下的 go-pipeline
+func DefaultDir() string {
+	if dir := os.Getenv("PIPELINE_STATE_DIR"); dir != "" {
+		return dir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "go-pipeline")
+	}
+	return filepath.Join(os.TempDir(), "go-pipeline")
+}
+
+// NewStore 创建使用dir作为状态目录的Store，dir为空时使用 DefaultDir
+func NewStore(dir string) *Store {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	return &Store{Dir: dir}
+}
+
+func (s *Store) runsDir() string {
+	return filepath.Join(s.Dir, "runs")
+}
+
+func (s *Store) recordPath(runID string) string {
+	return filepath.Join(s.runsDir(), runID+".json")
+}
+
+// LogDir 返回保存Job日志的目录，其中的文件路径见 pipeline.JobLogPath
+func (s *Store) LogDir() string {
+	return filepath.Join(s.Dir, "logs")
+}
+
+// Save 保存一次执行的记录
+func (s *Store) Save(rec *Record) error {
+	if rec.Result == nil || rec.Result.RunID == "" {
+		return errors.New("save run record: missing run id")
+	}
+	if err := os.MkdirAll(s.runsDir(), 0o755); err != nil {
+		return fmt.Errorf("save run record: %w", err)
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("save run record %s: %w", rec.Result.RunID, err)
+	}
+	// 先写入临时文件再重命名，避免读到写了一半的记录
+	path := s.recordPath(rec.Result.RunID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("save run record %s: %w", rec.Result.RunID, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("save run record %s: %w", rec.Result.RunID, err)
+	}
+	return nil
+}
+
+// Load 读取全部执行记录，按照开始时间从早到晚排列。无法解析的记录会被忽略
+func (s *Store) Load() ([]*Record, error) {
+	entries, err := os.ReadDir(s.runsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load run records: %w", err)
+	}
+	var records []*Record
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.runsDir(), entry.Name()))
+		if err != nil {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(data, rec); err != nil || rec.Result == nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	slices.SortStableFunc(records, func(a, b *Record) int {
+		if c := a.Result.StartTime.Compare(b.Result.StartTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Result.RunID, b.Result.RunID)
+	})
+	return records, nil
+}
+
+// Last 返回配置文件config最近一次的执行记录，没有时返回 ErrNotFound
+func (s *Store) Last(config string) (*Record, error) {
+	records, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Config == config {
+			return records[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w for %s", ErrNotFound, config)
+}
+
+// Get 返回执行ID为id的记录，id也可以是能唯一确定一次执行的前缀
+func (s *Store) Get(id string) (*Record, error) {
+	runID, err := s.ResolveRunID(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.recordPath(runID))
+	if errors.Is(err, os.ErrNotExist) {
+		// 仍在执行，只有日志
+		return nil, fmt.Errorf("%w with id %s", ErrNotFound, runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load run record %s: %w", runID, err)
+	}
+	rec := &Record{}
+	if err := json.Unmarshal(data, rec); err != nil || rec.Result == nil {
+		return nil, fmt.Errorf("load run record %s: invalid record", runID)
+	}
+	return rec, nil
+}
+
+// ResolveRunID 返回完整的执行ID，id可以是能唯一确定一次执行的前缀。
+// 仍在执行的流水线还没有记录，但已经有日志，同样可以被找到
+func (s *Store) ResolveRunID(id string) (string, error) {
+	ids := make(map[string]bool)
+	if entries, err := os.ReadDir(s.runsDir()); err == nil {
+		for _, entry := range entries {
+			if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+				ids[name] = true
+			}
+		}
+	}
+	if entries, err := os.ReadDir(s.LogDir()); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				ids[entry.Name()] = true
+			}
+		}
+	}
+	if ids[id] {
+		return id, nil
+	}
+	var matched []string
+	for runID := range ids {
+		if id != "" && strings.HasPrefix(runID, id) {
+			matched = append(matched, runID)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("%w with id %s", ErrNotFound, id)
+	case 1:
+		return matched[0], nil
+	default:
+		return "", fmt.Errorf("run id %s is ambiguous: matches %d runs", id, len(matched))
+	}
+}
+
+// Prune 删除在before之前开始的记录，以及除最近keep次之外的记录，连同这些执行的日志，返回被删除的记录。
+// before为零值时不按时间删除，keep不大于0时不按数量删除
+func (s *Store) Prune(before time.Time, keep int) ([]*Record, error) {
+	records, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	var pruned []*Record
+	var errs []error
+	for i, rec := range records {
+		tooOld := !before.IsZero() && rec.Result.StartTime.Before(before)
+		tooMany := keep > 0 && i < len(records)-keep
+		if !tooOld && !tooMany {
+			continue
+		}
+		if err := os.Remove(s.recordPath(rec.Result.RunID)); err != nil {
+			errs = append(errs, fmt.Errorf("prune run record %s: %w", rec.Result.RunID, err))
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.LogDir(), rec.Result.RunID)); err != nil {
+			errs = append(errs, fmt.Errorf("prune logs of run %s: %w", rec.Result.RunID, err))
+		}
+		pruned = append(pruned, rec)
+	}
+	return pruned, errors.Join(errs...)
+}
@@ -0,0 +1,101 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+func TestStoreLastReturnsNewestRunOfConfig(t *testing.T) {
+	store := NewStore(t.TempDir())
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	records := []*Record{
+		{Config: "/a.yml", Result: &pipeline.RunResult{RunID: "run-1", StartTime: start, Status: pipeline.Failed}},
+		{Config: "/a.yml", Result: &pipeline.RunResult{RunID: "run-2", StartTime: start.Add(time.Minute), Status: pipeline.Success}},
+		{Config: "/b.yml", Result: &pipeline.RunResult{RunID: "run-3", StartTime: start.Add(2 * time.Minute), Status: pipeline.Failed}},
+	}
+	// 保存的顺序与执行的顺序无关
+	for _, i := range []int{1, 2, 0} {
+		if err := store.Save(records[i]); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	// 无法解析的记录被忽略
+	if err := os.WriteFile(filepath.Join(store.Dir, "runs", "broken.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := store.Last("/a.yml")
+	if err != nil {
+		t.Fatalf("Last() error = %v", err)
+	}
+	if last.Result.RunID != "run-2" || last.Result.Status != pipeline.Success {
+		t.Fatalf("Last() = %s (%s), want run-2 (Success)", last.Result.RunID, last.Result.Status)
+	}
+	if _, err := store.Last("/c.yml"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Last() error = %v, want ErrNotFound", err)
+	}
+	if err := store.Save(&Record{Config: "/a.yml", Result: &pipeline.RunResult{}}); err == nil {
+		t.Fatal("Save() without run id succeeded")
+	}
+}
+
+func TestStoreGetAndPrune(t *testing.T) {
+	store := NewStore(t.TempDir())
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i, id := range []string{"20240501100000-aaaa", "20240501100100-aaab", "20240501100200-bbbb", "20240501100300-cccc"} {
+		rec := &Record{Config: "/a.yml", Result: &pipeline.RunResult{RunID: id, StartTime: start.Add(time.Duration(i) * time.Minute)}}
+		if err := store.Save(rec); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	// 仍在执行的流水线只有日志，没有记录
+	for _, id := range []string{"20240501100000-aaaa", "20240501100400-dddd"} {
+		if err := os.MkdirAll(filepath.Join(store.LogDir(), id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id, err := store.ResolveRunID("20240501100400"); err != nil || id != "20240501100400-dddd" {
+		t.Fatalf("ResolveRunID() of a running run = %q, %v", id, err)
+	}
+	if _, err := store.Get("20240501100400-dddd"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() of a running run error = %v, want ErrNotFound", err)
+	}
+
+	if rec, err := store.Get("20240501100200"); err != nil || rec.Result.RunID != "20240501100200-bbbb" {
+		t.Fatalf("Get() by prefix = %v, %v", rec, err)
+	}
+	if _, err := store.Get("20240501100"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Fatalf("Get() ambiguous prefix error = %v", err)
+	}
+
+	// 按时间删除最早的一次，再按数量只保留最近的两次
+	pruned, err := store.Prune(start.Add(30*time.Second), 0)
+	if err != nil || len(pruned) != 1 || pruned[0].Result.RunID != "20240501100000-aaaa" {
+		t.Fatalf("Prune(before) = %d records, %v", len(pruned), err)
+	}
+	if _, err := os.Stat(filepath.Join(store.LogDir(), "20240501100000-aaaa")); !os.IsNotExist(err) {
+		t.Fatalf("logs of the pruned run still exist: %v", err)
+	}
+	if pruned, err = store.Prune(time.Time{}, 2); err != nil || len(pruned) != 1 {
+		t.Fatalf("Prune(keep) = %d records, %v", len(pruned), err)
+	}
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, rec := range records {
+		ids = append(ids, rec.Result.RunID)
+	}
+	if want := []string{"20240501100200-bbbb", "20240501100300-cccc"}; !slices.Equal(ids, want) {
+		t.Fatalf("remaining runs = %v, want %v", ids, want)
+	}
+}
@@ -7,4 +7,7 @@ const (
 	NoSilenceKey ContextKey = "no-silence"
 	TraceKey     ContextKey = "trace"
 	DryRunKey    ContextKey = "dry-run"
+	JobsKey      ContextKey = "jobs" // 同时执行的Job的最大总权重
+	// 只在Job失败时输出其Actions和Hooks的输出
+	OutputOnFailureKey ContextKey = "output-on-failure"
 )
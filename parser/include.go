@@ -15,9 +15,9 @@ import (
 
 const includesKey = "includes"
 
-func loadConfigNode(configPath string) (*yaml.Node, error) {
-	node, _, err := loadConfigNodeWithStack(configPath, nil)
-	return node, err
+// loadConfigNode 加载配置文件及其include的文件，返回合并后的配置节点，以及每个配置项路径的来源文件
+func loadConfigNode(configPath string) (*yaml.Node, map[string]string, error) {
+	return loadConfigNodeWithStack(configPath, nil)
 }
 
 // 递归加载配置文件，并把 includes 引入的配置合并到当前配置前面。
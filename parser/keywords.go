@@ -10,25 +10,41 @@ const (
 	keyWordCron     = "cron"
 	keywordIncludes = "includes"
 
+	keywordStopSignal      = "stop_signal"
+	keywordStopGracePeriod = "stop_grace_period"
+
 	keywordNotifiers = "notifiers"
 
-	keywordEnvs    = "envs"
-	keywordWorkdir = "workdir"
+	keywordEnvs     = "envs"
+	keywordWorkdir  = "workdir"
+	keywordFailFast = "fail_fast"
+	keywordLockDir  = "lock_dir"
 
 	keywordStages = "stages"
+	keywordWhen   = "when"
 
-	keywordJobs         = "jobs"
-	keywordStage        = "stage"
-	keywordActions      = "actions"
-	keywordTimeout      = "timeout"
-	keywordAllowFailure = "allow_failure"
-	keywordExports      = "exports"
-	keywordRules        = "rules"
-	keywordOn           = "on"
-	keywordSkips        = "skips"
-	keywordHooks        = "hooks"
-	keywordHookBefore   = "before"
-	keywordHookAfter    = "after"
+	keywordJobs          = "jobs"
+	keywordStage         = "stage"
+	keywordActions       = "actions"
+	keywordTimeout       = "timeout"
+	keywordAllowFailure  = "allow_failure"
+	keywordExports       = "exports"
+	keywordRules         = "rules"
+	keywordOn            = "on"
+	keywordSkips         = "skips"
+	keywordHooks         = "hooks"
+	keywordHookBefore    = "before"
+	keywordHookAfter     = "after"
+	keywordHookSuccess   = "on_success"
+	keywordHookFailure   = "on_failure"
+	keywordHookAlways    = "always"
+	keywordNeeds         = "needs"
+	keywordMatrix        = "matrix"
+	keywordRetry         = "retry"
+	keywordWeight        = "weight"
+	keywordMaxParallel   = "max_parallel"
+	keywordResourceGroup = "resource_group"
+	keywordTags          = "tags"
 )
 
 var keywordMap = []string{
@@ -37,10 +53,15 @@ var keywordMap = []string{
 	keywordShell,
 	keyWordCron,
 	keywordIncludes,
+	keywordStopSignal,
+	keywordStopGracePeriod,
 	keywordNotifiers,
 	keywordEnvs,
 	keywordWorkdir,
+	keywordFailFast,
+	keywordLockDir,
 	keywordStages,
+	keywordWhen,
 	keywordJobs,
 	keywordStage,
 	keywordActions,
@@ -53,6 +74,16 @@ var keywordMap = []string{
 	keywordHooks,
 	keywordHookBefore,
 	keywordHookAfter,
+	keywordHookSuccess,
+	keywordHookFailure,
+	keywordHookAlways,
+	keywordNeeds,
+	keywordMatrix,
+	keywordRetry,
+	keywordWeight,
+	keywordMaxParallel,
+	keywordResourceGroup,
+	keywordTags,
 }
 
 func IsKeyword(token string) bool {
@@ -61,12 +92,18 @@ func IsKeyword(token string) bool {
 
 // 仅允许出现一次的关键字
 var singletonKeys = map[string]struct{}{
-	keywordName:    {},
-	keywordVersion: {},
-	keywordShell:   {},
-	keyWordCron:    {},
-	keywordWorkdir: {},
-	keywordStages:  {},
+	keywordName:     {},
+	keywordVersion:  {},
+	keywordShell:    {},
+	keyWordCron:     {},
+	keywordWorkdir:  {},
+	keywordStages:   {},
+	keywordFailFast: {},
+	keywordLockDir:  {},
+	keywordTimeout:  {},
+
+	keywordStopSignal:      {},
+	keywordStopGracePeriod: {},
 }
 
 func isMergeableKey(key string) bool {
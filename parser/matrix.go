@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	matrixExclude = "exclude"
+	matrixInclude = "include"
+)
+
+// matrixConf 描述一个Job的矩阵展开方式：
+// 除 exclude/include 外的每个key都是一个维度，所有维度的笛卡尔积即为该Job的全部变体；
+// exclude 中的条目会剔除匹配的组合，include 中的条目则作为额外的组合追加到末尾。
+type matrixConf struct {
+	Axes    DictList[string, []string]
+	Exclude []DictList[string, string]
+	Include []DictList[string, string]
+}
+
+func (m *matrixConf) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("matrix must be a mapping, got %s", value.ShortTag())
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		key := value.Content[i].Value
+		node := value.Content[i+1]
+		switch key {
+		case matrixExclude:
+			if err := node.Decode(&m.Exclude); err != nil {
+				return fmt.Errorf("matrix exclude: %w", err)
+			}
+		case matrixInclude:
+			if err := node.Decode(&m.Include); err != nil {
+				return fmt.Errorf("matrix include: %w", err)
+			}
+		default:
+			var values []string
+			switch node.Kind {
+			case yaml.ScalarNode:
+				values = []string{node.Value}
+			case yaml.SequenceNode:
+				if err := node.Decode(&values); err != nil {
+					return fmt.Errorf("matrix axis %s: %w", key, err)
+				}
+			default:
+				return fmt.Errorf("matrix axis %s must be a scalar or a list, got %s", key, node.ShortTag())
+			}
+			if len(values) == 0 {
+				return fmt.Errorf("matrix axis %s must not be empty", key)
+			}
+			if _, exists := m.Axes.Find(key); exists {
+				return fmt.Errorf("duplicate matrix axis %s", key)
+			}
+			m.Axes.Append(key, values)
+		}
+	}
+	for _, exclude := range m.Exclude {
+		if len(exclude) == 0 {
+			return fmt.Errorf("matrix exclude entries must not be empty")
+		}
+		for _, item := range exclude {
+			if _, exists := m.Axes.Find(item.Key); !exists {
+				return fmt.Errorf("matrix exclude references undefined axis %s", item.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// Combinations 按维度声明的顺序返回矩阵展开后的全部组合
+func (m *matrixConf) Combinations() []DictList[string, string] {
+	var combos []DictList[string, string]
+	if len(m.Axes) > 0 {
+		combos = []DictList[string, string]{{}}
+		for _, axis := range m.Axes {
+			next := make([]DictList[string, string], 0, len(combos)*len(axis.Value))
+			for _, combo := range combos {
+				for _, value := range axis.Value {
+					item := make(DictList[string, string], len(combo), len(combo)+1)
+					copy(item, combo)
+					item.Append(axis.Key, value)
+					next = append(next, item)
+				}
+			}
+			combos = next
+		}
+	}
+
+	result := make([]DictList[string, string], 0, len(combos)+len(m.Include))
+	for _, combo := range combos {
+		if !m.excluded(combo) {
+			result = append(result, combo)
+		}
+	}
+	return append(result, m.Include...)
+}
+
+func (m *matrixConf) excluded(combo DictList[string, string]) bool {
+	for _, exclude := range m.Exclude {
+		matched := true
+		for _, item := range exclude {
+			if value, ok := combo.Find(item.Key); !ok || value != item.Value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MatrixJobName 返回矩阵Job的一个变体的名称：Job名加上按维度顺序拼接的变量值，例如 build_job-Debug-gcc
+func MatrixJobName(jobName string, combo DictList[string, string]) string {
+	parts := []string{jobName}
+	for _, item := range combo {
+		parts = append(parts, item.Value)
+	}
+	return strings.Join(parts, "-")
+}
+
+// DuplicateJobName 展开矩阵后被两个Job使用的Job名，Job和Other可能是同一个矩阵Job
+type DuplicateJobName struct {
+	Name  string
+	Job   string // 后出现的Job
+	Other string // 先出现的Job
+}
+
+// FindDuplicateJobNames 查找展开矩阵后重名的Job。needs、选择要执行的Job、重新执行失败的Job以及日志文件都通过Job名找到Job，
+// 因此Job名在整个流水线中必须唯一。被跳过的Job不会被创建，不参与检查
+func FindDuplicateJobNames(config *PipelineConf) []DuplicateJobName {
+	var duplicates []DuplicateJobName
+	owners := make(map[string]string)
+	for _, jobName := range sortedJobNames(config) {
+		job := config.Jobs[jobName]
+		if isSkippedItem(config, jobName) || isSkippedItem(config, job.Stage) {
+			continue
+		}
+		names := []string{jobName}
+		if job.Matrix != nil {
+			names = names[:0]
+			for _, combo := range job.Matrix.Combinations() {
+				names = append(names, MatrixJobName(jobName, combo))
+			}
+		}
+		for _, name := range names {
+			if owner, exists := owners[name]; exists {
+				duplicates = append(duplicates, DuplicateJobName{Name: name, Job: jobName, Other: owner})
+				continue
+			}
+			owners[name] = jobName
+		}
+	}
+	return duplicates
+}
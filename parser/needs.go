@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// checkNeeds 校验Job之间通过 needs 声明的依赖关系：
+//  1. 被依赖的Job必须存在；
+//  2. 只允许依赖同一Stage或之前Stage中的Job（保持只能向前依赖的约定，否则会与Stage的串行执行互相等待）；
+//  3. 依赖关系不能成环。
+func checkNeeds(config *PipelineConf, c *checker) {
+	stageIndex := make(map[string]int, len(config.Stages))
+	for i, stage := range config.Stages {
+		if _, exists := stageIndex[stage.Name]; !exists {
+			stageIndex[stage.Name] = i
+		}
+	}
+
+	jobNames := sortedJobNames(config)
+	for _, jobName := range jobNames {
+		job := config.Jobs[jobName]
+		for i, need := range job.Needs {
+			path := []string{jobName, keywordNeeds, strconv.Itoa(i)}
+			needJob, exists := config.Jobs[need]
+			if !exists {
+				c.addf(path, "job %s needs undefined job %s", jobName, need)
+				continue
+			}
+			jobStage, jobOk := stageIndex[job.Stage]
+			needStage, needOk := stageIndex[needJob.Stage]
+			if jobOk && needOk && needStage > jobStage {
+				c.addf(path, "job %s in stage %s needs job %s from later stage %s", jobName, job.Stage, need, needJob.Stage)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(jobNames))
+	var stack []string
+	var visit func(jobName string)
+	visit = func(jobName string) {
+		state[jobName] = visiting
+		stack = append(stack, jobName)
+		for i, need := range config.Jobs[jobName].Needs {
+			if _, exists := config.Jobs[need]; !exists {
+				continue
+			}
+			switch state[need] {
+			case unvisited:
+				visit(need)
+			case visiting:
+				// 每个环只会在回到环上的Job时被发现一次
+				cycle := append(slices.Clone(stack[slices.Index(stack, need):]), need)
+				c.addf([]string{jobName, keywordNeeds, strconv.Itoa(i)}, "needs cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[jobName] = visited
+	}
+	for _, jobName := range jobNames {
+		if state[jobName] == unvisited {
+			visit(jobName)
+		}
+	}
+}
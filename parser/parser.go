@@ -2,7 +2,6 @@ package parser
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -13,14 +12,22 @@ import (
 type PipelineConf struct {
 	Name    string `yaml:"name" validate:"required"`
 	Version string `yaml:"version" validate:"required"`
-	Shell   string `yaml:"shell,omitempty"`
+	Shell   string `yaml:"shell,omitempty" validate:"omitempty,oneof=bash sh cmd powershell"`
 	Cron    string `yaml:"cron,omitempty"`
+	// 超时或取消时先向Action的进程树发送 StopSignal，超过 StopGracePeriod 后强制杀死
+	StopSignal      string `yaml:"stop_signal,omitempty" validate:"omitempty,oneof=SIGTERM SIGINT SIGQUIT SIGHUP SIGKILL"`
+	StopGracePeriod string `yaml:"stop_grace_period,omitempty"`
 	// NOTE 使用指针，这样可以判断是否存在该字段
 	Notifiers *notifiersConf           `yaml:"notifiers,omitempty"`
 	Envs      DictList[string, string] `yaml:"envs,omitempty"`
 	Workdir   string                   `yaml:"workdir,omitempty"`
-	Stages    []string                 `yaml:"stages" validate:"required"`
-	Skips     []string                 `yaml:"skips,omitempty"`
+	FailFast  bool                     `yaml:"fail_fast,omitempty"`
+	LockDir   string                   `yaml:"lock_dir,omitempty"`
+	// Timeout 整个流水线的执行时间上限，超时后取消仍在执行的Job并将剩余的工作标记为超时
+	Timeout string      `yaml:"timeout,omitempty"`
+	Hooks   HooksConf   `yaml:"hooks,omitempty"`
+	Stages  []StageConf `yaml:"stages" validate:"required,dive"`
+	Skips   []string    `yaml:"skips,omitempty"`
 	// NOTE gopkg.in/yaml.v3 库中，结构体字段的声明顺序会影响解析优先级。如果 inline 字段（Jobs）在结构体中声明的位置早于其他关键字段（如 Stages/Skips），可能导致部分嵌套字段被意外忽略。
 	Jobs map[string]jobConf `yaml:",inline" validate:"dive"`
 }
@@ -34,18 +41,31 @@ type notifiersConf struct {
 
 type jobConf struct {
 	Stage        string                   `yaml:"stage" validate:"required"`
-	Actions      []string                 `yaml:"actions"`
+	Actions      []ActionConf             `yaml:"actions" validate:"dive"`
 	Timeout      string                   `yaml:"timeout,omitempty"`
 	AllowFailure bool                     `yaml:"allow_failure,omitempty"`
 	Envs         DictList[string, string] `yaml:"envs,omitempty"`
 	Rules        []RuleConf               `yaml:"rules,omitempty" validate:"omitempty,min=1,dive"`
 	Exports      DictList[string, string] `yaml:"exports,omitempty"`
-	Hooks        hooksConf                `yaml:"hooks,omitempty"`
+	Hooks        HooksConf                `yaml:"hooks,omitempty"`
+	// NOTE 使用nil和空切片区分"未声明needs"与"needs: []"，后者表示该Job不依赖任何Job，流水线开始时即可执行
+	Needs  []string    `yaml:"needs,omitempty"`
+	Matrix *matrixConf `yaml:"matrix,omitempty"`
+	Retry  *RetryConf  `yaml:"retry,omitempty"`
+	Weight int         `yaml:"weight,omitempty" validate:"min=0"`
+	// 占用同一资源组的Job互斥执行，资源组的名称也用作文件锁的文件名
+	ResourceGroup string `yaml:"resource_group,omitempty" validate:"omitempty,excludesall=/\\"`
+	// 通过 --tags 和 --exclude-tags 按照tags选择要执行的Job
+	Tags []string `yaml:"tags,omitempty"`
 }
 
-type hooksConf struct {
-	Before []string `yaml:"before,omitempty"`
-	After  []string `yaml:"after,omitempty"`
+// HooksConf 描述Pipeline、Stage或Job的hooks
+type HooksConf struct {
+	Before    []string `yaml:"before,omitempty"`
+	After     []string `yaml:"after,omitempty"`
+	OnSuccess []string `yaml:"on_success,omitempty"`
+	OnFailure []string `yaml:"on_failure,omitempty"`
+	Always    []string `yaml:"always,omitempty"`
 }
 
 // emailNotifierConf 邮件通知器配置
@@ -74,26 +94,14 @@ type smsNotifierConf struct {
 	AppKey string `yaml:"appkey" validate:"required"`
 }
 
-// ParseConfigFile 解析 YAML 配置文件
+// ParseConfigFile 解析 YAML 配置文件，配置中存在问题时返回包含全部问题的错误
 func ParseConfigFile(configPath string) (*PipelineConf, error) {
-	mergedNode, err := loadConfigNode(configPath)
+	config, problems, err := ValidateConfigFile(configPath)
 	if err != nil {
 		return nil, err
 	}
-
-	config := &PipelineConf{}
-	if err := mergedNode.Decode(config); err != nil {
-		return nil, fmt.Errorf("unmarshal config failed: %w", err)
-	}
-	// 校验配置信息
-	if err := validate.Struct(config); err != nil {
-		var validationErrors validator.ValidationErrors
-		if errors.As(err, &validationErrors) {
-			for _, e := range validationErrors {
-				// 这里只返回第一个校验错误
-				return nil, fmt.Errorf("validate config failed: %w, (field: %s, tag: %s)", e, e.Field(), e.Tag())
-			}
-		}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("validate config failed:\n%w", problems)
 	}
 	return config, nil
 }
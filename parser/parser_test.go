@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -95,7 +96,7 @@ build_job:
 	if job.Stage != "build" {
 		t.Fatalf("build_job.Stage = %q, want build", job.Stage)
 	}
-	if len(job.Actions) != 1 || job.Actions[0] != "echo from main" {
+	if len(job.Actions) != 1 || job.Actions[0].Run != "echo from main" {
 		t.Fatalf("build_job.Actions = %#v, want main override", job.Actions)
 	}
 	if job.Timeout != "1m" {
@@ -383,7 +384,7 @@ stages:
 		t.Fatalf("ParseConfigFile() error = %v", err)
 	}
 	got := conf.Jobs["ordered_job"].Actions
-	if len(got) != 1 || got[0] != "echo b" {
+	if len(got) != 1 || got[0].Run != "echo b" {
 		t.Fatalf("ordered_job.Actions = %#v, want last sorted include to win", got)
 	}
 }
@@ -435,7 +436,7 @@ stages:
 		t.Fatalf("ParseConfigFile() error = %v", err)
 	}
 	got := conf.Jobs["ordered_job"].Actions
-	if len(got) != 1 || got[0] != "echo z" {
+	if len(got) != 1 || got[0].Run != "echo z" {
 		t.Fatalf("ordered_job.Actions = %#v, want filename order with z.yml loaded last", got)
 	}
 }
@@ -623,6 +624,445 @@ job:
 	}
 }
 
+func TestParseConfigFileReadsJobNeeds(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+  - test
+build_job:
+  stage: build
+  actions:
+    - echo build
+lint_job:
+  stage: test
+  needs: []
+  actions:
+    - echo lint
+test_job:
+  stage: test
+  needs: [build_job]
+  actions:
+    - echo test
+`)
+
+	conf, err := ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	if needs := conf.Jobs["build_job"].Needs; needs != nil {
+		t.Fatalf("build_job.Needs = %#v, want nil", needs)
+	}
+	if needs := conf.Jobs["lint_job"].Needs; needs == nil || len(needs) != 0 {
+		t.Fatalf("lint_job.Needs = %#v, want empty non-nil", needs)
+	}
+	if needs := conf.Jobs["test_job"].Needs; len(needs) != 1 || needs[0] != "build_job" {
+		t.Fatalf("test_job.Needs = %#v, want [build_job]", needs)
+	}
+}
+
+func TestParseConfigFileRejectsInvalidNeeds(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name: "undefined",
+			config: `a_job:
+  stage: build
+  needs: [missing_job]
+  actions:
+    - echo a
+`,
+			want: "needs undefined job missing_job",
+		},
+		{
+			name: "later stage",
+			config: `a_job:
+  stage: build
+  needs: [b_job]
+  actions:
+    - echo a
+b_job:
+  stage: test
+  actions:
+    - echo b
+`,
+			want: "needs job b_job from later stage test",
+		},
+		{
+			name: "cycle",
+			config: `a_job:
+  stage: build
+  needs: [b_job]
+  actions:
+    - echo a
+b_job:
+  stage: build
+  needs: [a_job]
+  actions:
+    - echo b
+`,
+			want: "needs cycle: a_job -> b_job -> a_job",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := writeTestFile(t, t.TempDir(), "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+  - test
+`+tt.config)
+
+			_, err := ParseConfigFile(configPath)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ParseConfigFile() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileExpandsJobMatrix(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+build_job:
+  stage: build
+  matrix:
+    BUILD_TYPE: [Debug, Release]
+    CC: [gcc, clang]
+    exclude:
+      - BUILD_TYPE: Debug
+        CC: clang
+    include:
+      - BUILD_TYPE: RelWithDebInfo
+        CC: gcc
+  actions:
+    - echo $BUILD_TYPE $CC
+`)
+
+	conf, err := ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	matrix := conf.Jobs["build_job"].Matrix
+	if matrix == nil {
+		t.Fatalf("build_job.Matrix missing")
+	}
+	var got []string
+	for _, combo := range matrix.Combinations() {
+		var values []string
+		for _, item := range combo {
+			values = append(values, item.Key+"="+item.Value)
+		}
+		got = append(got, strings.Join(values, ","))
+	}
+	want := []string{
+		"BUILD_TYPE=Debug,CC=gcc",
+		"BUILD_TYPE=Release,CC=gcc",
+		"BUILD_TYPE=Release,CC=clang",
+		"BUILD_TYPE=RelWithDebInfo,CC=gcc",
+	}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Fatalf("Combinations() = %v, want %v", got, want)
+	}
+}
+
+func TestParseConfigFileRejectsMatrixExcludeOfUndefinedAxis(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+build_job:
+  stage: build
+  matrix:
+    BUILD_TYPE: [Debug, Release]
+    exclude:
+      - CC: clang
+  actions:
+    - echo ok
+`)
+
+	_, err := ParseConfigFile(configPath)
+	if err == nil || !strings.Contains(err.Error(), "undefined axis CC") {
+		t.Fatalf("ParseConfigFile() error = %v, want undefined axis error", err)
+	}
+}
+
+func TestParseConfigFileReadsJobRetry(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+short_job:
+  stage: build
+  retry: 2
+  actions:
+    - echo ok
+full_job:
+  stage: build
+  retry:
+    max: 3
+    backoff: 5s
+    on_exit_codes: [1, 137]
+  actions:
+    - echo ok
+`)
+
+	conf, err := ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	if retry := conf.Jobs["short_job"].Retry; retry == nil || retry.Max != 2 {
+		t.Fatalf("short_job.Retry = %#v, want max 2", retry)
+	}
+	retry := conf.Jobs["full_job"].Retry
+	if retry == nil || retry.Max != 3 || retry.Backoff != "5s" || len(retry.OnExitCodes) != 2 || retry.OnExitCodes[1] != 137 {
+		t.Fatalf("full_job.Retry = %#v, want max 3, backoff 5s and exit codes [1 137]", retry)
+	}
+}
+
+func TestValidateConfigFileRejectsJobScopeOnAction(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+build_job:
+  stage: build
+  actions:
+    - echo ok
+    - run: make
+      retry:
+        max: 2
+        scope: job
+        backoff: soon
+`)
+
+	_, problems, err := ValidateConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() error = %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("ValidateConfigFile() problems = %v, want 2 problems", problems)
+	}
+	if got := problems[0].Error(); !strings.Contains(got, "pipeline.yaml:12:16: retry scope job of job build_job is only allowed on the job") {
+		t.Fatalf("problem = %q, want the scope problem", got)
+	}
+}
+
+func TestValidateConfigFileAllowsZeroRetryBackoff(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+build_job:
+  stage: build
+  timeout: 0s
+  retry:
+    max: 2
+    backoff: 0s
+  actions:
+    - run: make
+      retry:
+        max: 1
+        backoff: -1s
+`)
+
+	_, problems, err := ValidateConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() error = %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("ValidateConfigFile() problems = %v, want 2 problems", problems)
+	}
+	if got := problems[0].Error(); !strings.Contains(got, `invalid duration "0s" for timeout: must be positive`) {
+		t.Fatalf("problem = %q, want the zero timeout problem", got)
+	}
+	if got := problems[1].Error(); !strings.Contains(got, `invalid duration "-1s" for backoff: must not be negative`) {
+		t.Fatalf("problem = %q, want the negative backoff problem", got)
+	}
+}
+
+func TestParseConfigFileReadsStageWhen(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+fail_fast: true
+stages:
+  - build
+  - name: notify
+    when: on_failure
+  - name: cleanup
+    when: always
+    fail_fast: false
+build_job:
+  stage: build
+  actions:
+    - echo ok
+`)
+
+	conf, err := ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	noFailFast := false
+	want := []StageConf{{Name: "build"}, {Name: "notify", When: WhenOnFailure}, {Name: "cleanup", When: WhenAlways, FailFast: &noFailFast}}
+	if !conf.FailFast {
+		t.Fatalf("FailFast = false, want true")
+	}
+	if !reflect.DeepEqual(conf.Stages, want) {
+		t.Fatalf("Stages = %#v, want %#v", conf.Stages, want)
+	}
+}
+
+func TestValidateConfigFileRejectsInvalidStageWhen(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+  - name: cleanup
+    when: sometimes
+  - when: always
+`)
+
+	_, problems, err := ValidateConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() error = %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("ValidateConfigFile() problems = %v, want 2 problems", problems)
+	}
+	if p := problems[0]; p.Path != "stages[1].when" || p.Pos.Line != 6 || !strings.Contains(p.Message, "'oneof' tag") {
+		t.Fatalf("problem 0 = %+v at %s, want invalid when at line 6", p, p.Pos)
+	}
+	if p := problems[1]; p.Path != "stages[2].name" || p.Pos.Line != 7 || !strings.Contains(p.Message, "'required' tag") {
+		t.Fatalf("problem 1 = %+v at %s, want missing name at line 7", p, p.Pos)
+	}
+}
+
+func TestValidateConfigFileChecksPipelineAndStageTimeouts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+timeout: 1h
+stages:
+  - name: build
+    timeout: 10m
+  - name: test
+    timeout: soon
+build_job:
+  stage: build
+  actions:
+    - echo ok
+`)
+
+	conf, problems, err := ValidateConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() error = %v", err)
+	}
+	if conf.Timeout != "1h" || conf.Stages[0].Timeout != "10m" {
+		t.Fatalf("timeouts = %q, %q, want 1h, 10m", conf.Timeout, conf.Stages[0].Timeout)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("ValidateConfigFile() problems = %v, want 1 problem", problems)
+	}
+	if p := problems[0]; p.Path != "stages[1].timeout" || p.Pos.Line != 8 || !strings.Contains(p.Message, `invalid duration "soon"`) {
+		t.Fatalf("problem = %+v at %s, want invalid stage timeout at line 8", p, p.Pos)
+	}
+}
+
+func TestValidateConfigFileRejectsInvalidTags(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `name: test
+version: 1.0.0
+stages:
+  - build
+build_job:
+  stage: build
+  tags: [quick, "slow && flaky"]
+  actions:
+    - echo ok
+`)
+
+	conf, problems, err := ValidateConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() error = %v", err)
+	}
+	if tags := conf.Jobs["build_job"].Tags; !reflect.DeepEqual(tags, []string{"quick", "slow && flaky"}) {
+		t.Fatalf("Tags = %v, want [quick slow && flaky]", tags)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("ValidateConfigFile() problems = %v, want 1 problem", problems)
+	}
+	if p := problems[0]; p.Path != "build_job.tags[1]" || p.Pos.Line != 7 || !strings.Contains(p.Message, `invalid tag "slow && flaky"`) {
+		t.Fatalf("problem = %+v at %s, want invalid tag at line 7", p, p.Pos)
+	}
+}
+
+func TestValidateConfigFileReportsAllProblemsWithPositions(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeTestFile(t, tmpDir, "jobs.yaml", `lint_job:
+  stage: lint
+  timeout: 5x
+  actions:
+    - echo lint
+`)
+	configPath := writeTestFile(t, tmpDir, "pipeline.yaml", `includes: jobs.yaml
+name: test
+version: 1.0.0
+shell: zsh
+stages:
+  - build
+  - build
+build_job:
+  stage: build
+  needs: [missing_job]
+  actions:
+    - echo build
+`)
+
+	_, problems, err := ValidateConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() error = %v", err)
+	}
+	jobsPath := filepath.Join(tmpDir, "jobs.yaml")
+	want := []struct {
+		file      string
+		line, col int
+		path      string
+		message   string
+	}{
+		{jobsPath, 2, 10, "lint_job.stage", "job lint_job references undefined stage lint"},
+		{jobsPath, 3, 12, "lint_job.timeout", `invalid duration "5x"`},
+		{configPath, 4, 8, "shell", "'Shell' failed on the 'oneof' tag"},
+		{configPath, 7, 5, "stages[1]", `duplicate stage "build"`},
+		{configPath, 10, 11, "build_job.needs[0]", "job build_job needs undefined job missing_job"},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("ValidateConfigFile() problems = %v, want %d problems", problems, len(want))
+	}
+	for i, w := range want {
+		p := problems[i]
+		if p.Pos.File != w.file || p.Pos.Line != w.line || p.Pos.Column != w.col || p.Path != w.path || !strings.Contains(p.Message, w.message) {
+			t.Fatalf("problem %d = %+v at %s, want %s:%d:%d %s %q", i, p, p.Pos, w.file, w.line, w.col, w.path, w.message)
+		}
+	}
+
+	if _, err := ParseConfigFile(configPath); err == nil || !strings.Contains(err.Error(), "duplicate stage") || !strings.Contains(err.Error(), "undefined job missing_job") {
+		t.Fatalf("ParseConfigFile() error = %v, want all problems", err)
+	}
+}
+
 func writeTestFile(t *testing.T, root, name, content string) string {
 	t.Helper()
 	path := filepath.Join(root, filepath.FromSlash(name))
@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 重试的范围
+const (
+	RetryScopeAction = "action" // 只重新执行失败的Action（默认）
+	RetryScopeJob    = "job"    // 从第一个Action开始重新执行整个Job
+)
+
+// RetryConf 描述Job失败后的重试策略，支持两种写法：
+//
+//	retry: 2
+//	retry:
+//	  max: 2
+//	  backoff: 5s
+//	  on_exit_codes: [1, 137]
+//	  scope: job
+//
+// 也可以在单个Action上覆盖Job的重试策略，此时scope只能是action
+type RetryConf struct {
+	Max         int    `yaml:"max" validate:"min=0"`
+	Backoff     string `yaml:"backoff,omitempty"`
+	OnExitCodes []int  `yaml:"on_exit_codes,omitempty"`
+	Scope       string `yaml:"scope,omitempty" validate:"omitempty,oneof=action job"`
+}
+
+func (r *RetryConf) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Decode(&r.Max)
+	case yaml.MappingNode:
+		// 使用别名类型避免递归调用UnmarshalYAML
+		type plain RetryConf
+		return value.Decode((*plain)(r))
+	default:
+		return fmt.Errorf("retry must be an integer or a mapping, got %s", value.ShortTag())
+	}
+}
+
+// ActionConf 描述Job中的一个Action，支持两种写法：
+//
+//	actions:
+//	  - make build
+//	  - run: curl -fsSL https://example.com/archive.tar.gz -o archive.tar.gz
+//	    retry: 3
+type ActionConf struct {
+	Run   string     `yaml:"run" validate:"required"`
+	Retry *RetryConf `yaml:"retry,omitempty"` // 覆盖Job的重试策略，retry: 0 表示该Action不重试
+}
+
+func (a *ActionConf) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Decode(&a.Run)
+	case yaml.MappingNode:
+		// 使用别名类型避免递归调用UnmarshalYAML
+		type plain ActionConf
+		return value.Decode((*plain)(a))
+	default:
+		return fmt.Errorf("action must be a string or a mapping, got %s", value.ShortTag())
+	}
+}
@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Stage执行条件，根据到目前为止的流水线状态决定是否执行该Stage
+const (
+	WhenOnSuccess = "on_success" // 之前的Stage全部成功时执行（默认）
+	WhenOnFailure = "on_failure" // 之前有Stage失败或超时时执行
+	WhenAlways    = "always"     // 总是执行，包括流水线被取消或超时后
+)
+
+// StageConf 描述一个Stage，支持两种写法：
+//
+//	stages:
+//	  - build
+//	  - name: cleanup
+//	    when: always
+//	    fail_fast: false
+//	    max_parallel: 2
+//	    timeout: 10m
+//	    hooks:
+//	      before:
+//	        - echo "cleaning up"
+type StageConf struct {
+	Name string `yaml:"name" validate:"required"`
+	When string `yaml:"when,omitempty" validate:"omitempty,oneof=on_success on_failure always"`
+	// NOTE 使用指针区分"未设置"与"fail_fast: false"，未设置时使用流水线的配置
+	FailFast    *bool     `yaml:"fail_fast,omitempty"`
+	MaxParallel int       `yaml:"max_parallel,omitempty" validate:"min=0"`
+	Timeout     string    `yaml:"timeout,omitempty"`
+	Hooks       HooksConf `yaml:"hooks,omitempty"`
+}
+
+func (s *StageConf) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Decode(&s.Name)
+	case yaml.MappingNode:
+		// 使用别名类型避免递归调用UnmarshalYAML
+		type plain StageConf
+		return value.Decode((*plain)(s))
+	default:
+		return fmt.Errorf("stage must be a string or a mapping, got %s", value.ShortTag())
+	}
+}
@@ -0,0 +1,376 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+)
+
+// Position 配置项在源文件中的位置，Line和Column从1开始，为0表示未知
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Problem 配置中的一个问题
+type Problem struct {
+	Pos     Position
+	Path    string // 出问题的配置项，例如 build_job.needs[0]
+	Message string
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Pos, p.Message)
+}
+
+// Problems 配置中的全部问题，按照出现的位置排序
+type Problems []*Problem
+
+func (ps Problems) Error() string {
+	lines := make([]string, 0, len(ps))
+	for _, p := range ps {
+		lines = append(lines, p.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateConfigFile 解析并校验配置文件，一次性返回配置中的全部问题及其所在的文件和行列号（包括被include的文件）。
+// 只有配置文件本身无法加载（读取失败、YAML语法错误、includes错误等）时才返回error。
+func ValidateConfigFile(configPath string) (*PipelineConf, Problems, error) {
+	mergedNode, sources, err := loadConfigNode(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &PipelineConf{}
+	if err := mergedNode.Decode(config); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal config failed: %w", err)
+	}
+
+	c := &checker{root: mergedNode, sources: sources, file: displayPath(configPath)}
+	if err := validate.Struct(config); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			return nil, nil, fmt.Errorf("validate config failed: %w", err)
+		}
+		for _, e := range validationErrors {
+			message := fmt.Sprintf("field validation for '%s' failed on the '%s' tag", e.Field(), e.Tag())
+			if e.Param() != "" {
+				message += fmt.Sprintf(" (%s)", e.Param())
+			}
+			c.addf(yamlPath(e.StructNamespace()), "%s", message)
+		}
+	}
+	checkStages(config, c)
+	checkJobNames(config, c)
+	checkDurations(config, c)
+	checkRetries(config, c)
+	checkNeeds(config, c)
+	checkTags(config, c)
+
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		pi, pj := c.problems[i].Pos, c.problems[j].Pos
+		if pi.File != pj.File {
+			return pi.File < pj.File
+		}
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Column < pj.Column
+	})
+	return config, c.problems, nil
+}
+
+// checker 收集配置中的问题，并根据配置项的路径在合并后的YAML节点中找到其位置
+type checker struct {
+	root     *yaml.Node
+	sources  map[string]string // 配置项路径 -> 来源文件，见 collectSources
+	file     string            // 找不到来源时使用的文件
+	problems Problems
+}
+
+func (c *checker) addf(path []string, format string, args ...any) {
+	c.problems = append(c.problems, &Problem{
+		Pos:     c.position(path),
+		Path:    formatPath(path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// position 返回path对应的配置项的位置。配置项不存在时（例如缺少必填字段）返回最近的父配置项的位置
+func (c *checker) position(path []string) Position {
+	pos := Position{File: c.file}
+	node := documentMapping(c.root)
+	var mappingPath []string
+	for _, part := range path {
+		var next, at *yaml.Node
+		switch {
+		case node == nil:
+		case node.Kind == yaml.MappingNode:
+			if i := findMappingKeyIndex(node, part); i >= 0 {
+				next = node.Content[i+1]
+				at = node.Content[i]
+				// 标量的值比键更能说明问题出在哪里
+				if next.Kind == yaml.ScalarNode {
+					at = next
+				}
+				mappingPath = append(mappingPath, part)
+			}
+		case node.Kind == yaml.SequenceNode:
+			if i, err := strconv.Atoi(part); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+				at = next
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+		pos.Line, pos.Column = at.Line, at.Column
+	}
+	// 来源只记录到mapping的层级，从最深的路径开始查找
+	for i := len(mappingPath); i > 0; i-- {
+		if source, ok := c.sources[strings.Join(mappingPath[:i], ".")]; ok {
+			pos.File = displayPath(source)
+			break
+		}
+	}
+	return pos
+}
+
+// checkStages 检查重复的Stage，以及Job引用了未定义的Stage
+func checkStages(config *PipelineConf, c *checker) {
+	stages := make(map[string]struct{}, len(config.Stages))
+	for i, stage := range config.Stages {
+		if stage.Name == "" {
+			continue
+		}
+		if _, exists := stages[stage.Name]; exists {
+			c.addf([]string{keywordStages, strconv.Itoa(i)}, "duplicate stage %q", stage.Name)
+			continue
+		}
+		stages[stage.Name] = struct{}{}
+	}
+	for _, jobName := range sortedJobNames(config) {
+		job := config.Jobs[jobName]
+		// 与创建流水线时的规则保持一致：被跳过的Job不会被创建，也就不必检查
+		if job.Stage == "" || isSkippedItem(config, jobName) || isSkippedItem(config, job.Stage) {
+			continue
+		}
+		if _, exists := stages[job.Stage]; !exists {
+			c.addf([]string{jobName, keywordStage}, "job %s references undefined stage %s", jobName, job.Stage)
+		}
+	}
+}
+
+// checkJobNames 检查展开矩阵后重名的Job
+func checkJobNames(config *PipelineConf, c *checker) {
+	for _, dup := range FindDuplicateJobNames(config) {
+		path := []string{dup.Job}
+		if config.Jobs[dup.Job].Matrix != nil {
+			path = append(path, keywordMatrix)
+		}
+		c.addf(path, "duplicate job name %q: used by both job %s and job %s", dup.Name, dup.Other, dup.Job)
+	}
+}
+
+// checkDurations 检查所有表示时长的配置项。重试的backoff可以为0，表示立即重试
+func checkDurations(config *PipelineConf, c *checker) {
+	checkDuration := func(path []string, value string, allowZero bool) {
+		if value == "" {
+			return
+		}
+		if d, err := time.ParseDuration(value); err != nil {
+			c.addf(path, "invalid duration %q for %s: %v", value, path[len(path)-1], err)
+		} else if d < 0 && allowZero {
+			c.addf(path, "invalid duration %q for %s: must not be negative", value, path[len(path)-1])
+		} else if d <= 0 && !allowZero {
+			c.addf(path, "invalid duration %q for %s: must be positive", value, path[len(path)-1])
+		}
+	}
+	check := func(path []string, value string) { checkDuration(path, value, false) }
+	checkBackoff := func(path []string, value string) { checkDuration(path, value, true) }
+	check([]string{keywordStopGracePeriod}, config.StopGracePeriod)
+	check([]string{keywordTimeout}, config.Timeout)
+	for i, stage := range config.Stages {
+		check([]string{keywordStages, strconv.Itoa(i), keywordTimeout}, stage.Timeout)
+	}
+	for _, jobName := range sortedJobNames(config) {
+		job := config.Jobs[jobName]
+		check([]string{jobName, keywordTimeout}, job.Timeout)
+		if job.Retry != nil {
+			checkBackoff([]string{jobName, keywordRetry, "backoff"}, job.Retry.Backoff)
+		}
+		for i, action := range job.Actions {
+			if action.Retry != nil {
+				checkBackoff([]string{jobName, keywordActions, strconv.Itoa(i), keywordRetry, "backoff"}, action.Retry.Backoff)
+			}
+		}
+	}
+}
+
+// checkRetries 检查Action上的重试策略：Action只能覆盖自身的重试，不能重新执行整个Job
+func checkRetries(config *PipelineConf, c *checker) {
+	for _, jobName := range sortedJobNames(config) {
+		for i, action := range config.Jobs[jobName].Actions {
+			if action.Retry != nil && action.Retry.Scope == RetryScopeJob {
+				c.addf([]string{jobName, keywordActions, strconv.Itoa(i), keywordRetry, "scope"}, "retry scope job of job %s is only allowed on the job, not on an action", jobName)
+			}
+		}
+	}
+}
+
+// IsTagName 判断name能否作为Job的tag。tag只能由字母、数字和 _-.:/ 组成，从而可以在 --tags 表达式中使用
+func IsTagName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.:/", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTags 检查所有Job的tags
+func checkTags(config *PipelineConf, c *checker) {
+	for _, jobName := range sortedJobNames(config) {
+		for i, tag := range config.Jobs[jobName].Tags {
+			if !IsTagName(tag) {
+				c.addf([]string{jobName, keywordTags, strconv.Itoa(i)}, "invalid tag %q of job %s: tags may only contain letters, digits and _-.:/", tag, jobName)
+			}
+		}
+	}
+}
+
+func isSkippedItem(config *PipelineConf, item string) bool {
+	return slices.Contains(config.Skips, item)
+}
+
+// sortedJobNames 返回排序后的Job名。map的遍历顺序不稳定，排序后再检查，保证同一份配置总是以同样的顺序报告问题
+func sortedJobNames(config *PipelineConf) []string {
+	jobNames := make([]string, 0, len(config.Jobs))
+	for jobName := range config.Jobs {
+		if IsKeyword(jobName) {
+			continue
+		}
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+	return jobNames
+}
+
+// yamlPath 将校验器给出的结构体字段路径（例如 PipelineConf.Jobs[build_job].Rules[0].On）转换为配置项路径（build_job.rules.0.on）
+func yamlPath(namespace string) []string {
+	var path []string
+	typ := reflect.TypeOf(PipelineConf{})
+	segments := splitNamespace(namespace)
+	for _, segment := range segments[min(1, len(segments)):] {
+		name, keys := segment, []string(nil)
+		if i := strings.IndexByte(segment, '['); i >= 0 {
+			name = segment[:i]
+			keys = strings.Split(strings.TrimSuffix(segment[i+1:], "]"), "][")
+		}
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			break
+		}
+		field, ok := typ.FieldByName(name)
+		if !ok {
+			break
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "" && !strings.Contains(field.Tag.Get("yaml"), "inline") {
+			tag = strings.ToLower(field.Name)
+		}
+		if tag != "" {
+			path = append(path, tag)
+		}
+		path = append(path, keys...)
+		typ = field.Type
+		for range keys {
+			for typ.Kind() == reflect.Pointer {
+				typ = typ.Elem()
+			}
+			if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+				typ = typ.Elem()
+			}
+		}
+	}
+	return path
+}
+
+// splitNamespace 按照'.'切分字段路径，但不切分[]中的map键
+func splitNamespace(namespace string) []string {
+	var segments []string
+	depth, start := 0, 0
+	for i := 0; i < len(namespace); i++ {
+		switch namespace[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				segments = append(segments, namespace[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(segments, namespace[start:])
+}
+
+// formatPath 将配置项路径格式化为 build_job.needs[0] 的形式
+func formatPath(path []string) string {
+	var b strings.Builder
+	for _, part := range path {
+		if _, err := strconv.Atoi(part); err == nil && b.Len() > 0 {
+			fmt.Fprintf(&b, "[%s]", part)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// displayPath 尽量使用相对于当前目录的路径展示文件，便于阅读
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return abs
+	}
+	return rel
+}
@@ -1,15 +1,14 @@
 package pipeline
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
-	"sync"
 
 	"github.com/Meha555/go-pipeline/internal"
 )
@@ -20,11 +19,14 @@ type Action struct {
 	Args   []string
 	Shell  [2]string // shellCmd, shellFlag
 	Envs   []string
+	Retry  *RetryPolicy // 在Job中执行时覆盖Job的重试策略
 	busy   bool
-	stdout io.ReadCloser
-	stderr io.ReadCloser
+	output *tailBuffer // 最近一次执行的输出（stdout和stderr混合）
 }
 
+// 每个Action最多保留的输出字节数，超出部分从头部丢弃
+const maxActionOutput = 32 * 1024
+
 var ErrActionBusy = fmt.Errorf("action is busy because is has not finished")
 
 func NewAction(shell [2]string, cmd string, args ...string) *Action {
@@ -40,20 +42,18 @@ func (a *Action) SetEnvs(envs []string) {
 	a.Envs = envs
 }
 
-func (a *Action) prepare(ctx context.Context) *exec.Cmd {
+func (a *Action) prepare(ctx context.Context, envs []string) *exec.Cmd {
 	// cmd := exec.CommandContext(ctx, a.Cmd, a.Args...)
 	cmd := ShellCommandContext(ctx, a.Shell[0], a.Shell[1], a.Cmd, a.Args...)
-	if len(a.Envs) > 0 {
-		cmd.Env = append(os.Environ(), a.Envs...)
-	}
-	// a.stdout, _ = cmd.StdoutPipe()
-	// a.stderr, _ = cmd.StderrPipe()
+	// 环境变量和工作目录都来自本次执行的运行环境，而不是当前进程
+	env := runEnvFrom(ctx)
+	cmd.Env = env.environ(envs...)
+	cmd.Dir = env.dir()
 	return cmd
 }
 
 // Exec 阻塞地执行动作
 func (a *Action) Exec(ctx context.Context) (err error) {
-	cmd := a.prepare(ctx)
 	if a.busy {
 		return ErrActionBusy
 	}
@@ -61,6 +61,14 @@ func (a *Action) Exec(ctx context.Context) (err error) {
 		a.busy = false
 	}()
 	a.busy = true
+	a.output = newTailBuffer(maxActionOutput)
+	return a.exec(ctx, a.Envs, a.output)
+}
+
+// exec 使用envs作为额外的环境变量阻塞地执行动作，并将输出的尾部保存到output中。
+// 不会修改Action本身，因此同一个Action可以在流水线的多次执行中同时被执行。
+func (a *Action) exec(ctx context.Context, envs []string, output *tailBuffer) (err error) {
+	cmd := a.prepare(ctx, envs)
 	if noSilence, ok := ctx.Value(internal.NoSilenceKey).(bool); ok && noSilence {
 		slog.Info(fmt.Sprintf("exec action: %s", a.String()), "action", a.String())
 	}
@@ -68,40 +76,37 @@ func (a *Action) Exec(ctx context.Context) (err error) {
 		slog.Info(a.String(), "action", a.String())
 		return
 	}
-	// 由于scanner.Scan()可能在cmd.Wait()关闭管道写端后继续读取而导致报错"file already closed"。这点在cmd.StdoutPipe()的文档中有说明。这里显式等待输出完成后再等待命令执行完成。
-	wg := &sync.WaitGroup{}
+	// 不论是否显示输出，都保留输出的尾部用于生成报告。
+	// 这里不使用StdoutPipe，而是交给exec.Cmd自己拷贝输出：Action启动的后台进程可能一直持有管道，
+	// 只有由exec.Cmd管理的管道才会在进程退出后最多等待WaitDelay就被关闭，不会导致Exec一直阻塞。
+	var out io.Writer = output
+	// 在Job中执行时，输出同时保存到Job的日志文件中
+	if log := jobLogFrom(ctx); log != nil {
+		fmt.Fprintf(log, "$ %s\n", strings.TrimSpace(a.String()))
+		out = io.MultiWriter(output, log)
+	}
+	cmd.Stdout, cmd.Stderr = out, out
 	if verbose, ok := ctx.Value(internal.VerboseKey).(bool); ok && verbose {
-		a.stdout, _ = cmd.StdoutPipe()
-		a.stderr, _ = cmd.StderrPipe()
-		wg.Add(2)
-		go readOutput(wg, a.stdout, os.Stdout)
-		go readOutput(wg, a.stderr, os.Stderr)
-		// } else {
-		// 	// 即使不显示输出，也要读取并丢弃输出，防止管道写端阻塞而导致当前goroutine卡死
-		// 	go readOutput(a.stdout, io.Discard)
-		// 	go readOutput(a.stderr, io.Discard)
+		cmd.Stdout = io.MultiWriter(os.Stdout, out)
+		cmd.Stderr = io.MultiWriter(os.Stderr, out)
 	}
 
-	err = cmd.Start()
-	if err == nil {
-		wg.Wait()
-		err = cmd.Wait()
+	err = cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// 命令本身已经成功退出，只是其启动的后台进程还持有输出管道
+		err = nil
 	}
 	return
 }
 
-func (a *Action) String() string {
-	return fmt.Sprintf("%s %s", a.Cmd, strings.Join(a.Args, ", "))
+// Output 返回最近一次执行的输出尾部
+func (a *Action) Output() string {
+	if a.output == nil {
+		return ""
+	}
+	return a.output.String()
 }
 
-func readOutput(wg *sync.WaitGroup, reader io.Reader, out io.Writer) {
-	defer wg.Done()
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		io.WriteString(out, scanner.Text())
-		io.WriteString(out, "\n")
-	}
-	if err := scanner.Err(); err != nil { // 说明不是io.EOF
-		slog.Error(fmt.Sprintf("read output error: %v", err), "error", err)
-	}
+func (a *Action) String() string {
+	return fmt.Sprintf("%s %s", a.Cmd, strings.Join(a.Args, ", "))
 }
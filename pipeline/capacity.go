@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// capacity 带权重的并发限制，用于限制同时执行的Job。
+// 等待者按照先来后到的顺序获得容量，避免权重大的Job一直等不到足够的容量
+type capacity struct {
+	limit   int
+	mu      sync.Mutex
+	used    int
+	waiters []*capacityWaiter
+}
+
+type capacityWaiter struct {
+	n     int
+	ready chan struct{}
+}
+
+// newCapacity 创建容量为limit的并发限制，limit<=0时表示不限制，返回nil
+func newCapacity(limit int) *capacity {
+	if limit <= 0 {
+		return nil
+	}
+	return &capacity{limit: limit}
+}
+
+// acquire 占用n个容量，容量不足时阻塞直到有足够的容量或者ctx结束。
+// n超过总容量时按总容量计算，即该Job执行时独占全部容量
+func (c *capacity) acquire(ctx context.Context, n int) (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+	n = min(max(n, 1), c.limit)
+	c.mu.Lock()
+	if len(c.waiters) == 0 && c.used+n <= c.limit {
+		c.used += n
+		c.mu.Unlock()
+		return n, nil
+	}
+	w := &capacityWaiter{n: n, ready: make(chan struct{})}
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return n, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		select {
+		case <-w.ready:
+			// 在ctx结束的同时获得了容量，需要归还
+			c.used -= n
+		default:
+			c.waiters = slices.DeleteFunc(c.waiters, func(other *capacityWaiter) bool { return other == w })
+		}
+		c.wake()
+		return 0, ctx.Err()
+	}
+}
+
+// release 归还acquire占用的容量
+func (c *capacity) release(n int) {
+	if c == nil || n == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used -= n
+	c.wake()
+}
+
+// wake 按顺序唤醒容量足够的等待者，调用时必须持有锁
+func (c *capacity) wake() {
+	for len(c.waiters) > 0 {
+		w := c.waiters[0]
+		if c.used+w.n > c.limit {
+			return
+		}
+		c.used += w.n
+		close(w.ready)
+		c.waiters = c.waiters[1:]
+	}
+}
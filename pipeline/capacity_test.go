@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Meha555/go-pipeline/internal"
+)
+
+func TestCapacityWakesWaitersInOrder(t *testing.T) {
+	c := newCapacity(3)
+	ctx := context.Background()
+	first, _ := c.acquire(ctx, 2)
+
+	heavy := make(chan int)
+	go func() {
+		n, _ := c.acquire(ctx, 5) // 超过总容量，按总容量计算
+		heavy <- n
+	}()
+	waitForWaiters(t, c, 1)
+	light := make(chan int)
+	go func() {
+		n, _ := c.acquire(ctx, 1)
+		light <- n
+	}()
+	waitForWaiters(t, c, 2)
+
+	// 容量虽然足够执行权重为1的Job，但它排在权重更大的Job后面
+	select {
+	case <-light:
+		t.Fatal("light waiter overtook heavy waiter")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.release(first)
+	if n := <-heavy; n != 3 {
+		t.Fatalf("heavy waiter acquired %d, want 3", n)
+	}
+	c.release(3)
+	if n := <-light; n != 1 {
+		t.Fatalf("light waiter acquired %d, want 1", n)
+	}
+}
+
+func TestCapacityAcquireStopsWhenContextEnds(t *testing.T) {
+	c := newCapacity(1)
+	used, _ := c.acquire(context.Background(), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.acquire(ctx, 1); err == nil {
+		t.Fatal("acquire() error = nil, want context error")
+	}
+	c.release(used)
+	if _, err := c.acquire(context.Background(), 1); err != nil {
+		t.Fatalf("acquire() after canceled waiter error = %v", err)
+	}
+}
+
+func waitForWaiters(t *testing.T, c *capacity, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		got := len(c.waiters)
+		c.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("waiters did not reach %d", n)
+}
+
+func TestStageMaxParallelAndGlobalJobsLimitConcurrency(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	track := func(log string) []*Action {
+		return []*Action{NewAction(p.Shell, "echo + >> "+log+"; sleep 0.2; echo - >> "+log)}
+	}
+	shards := NewStage("shards", p, WithMaxParallel(2))
+	for _, name := range []string{"shard1", "shard2", "shard3", "shard4", "shard5"} {
+		shards.AddJob(NewJob(name, track("shards.log"), shards))
+	}
+	p.AddStage(shards)
+	heavy := NewStage("heavy", p)
+	heavy.AddJob(NewJob("heavy1", track("heavy.log"), heavy, WithWeight(2)))
+	heavy.AddJob(NewJob("heavy2", track("heavy.log"), heavy, WithWeight(2)))
+	p.AddStage(heavy)
+
+	ctx := context.WithValue(context.Background(), internal.JobsKey, 3)
+	if status := p.Run(ctx); status != Success {
+		t.Fatalf("pipeline status = %s, want Success", status)
+	}
+	for log, want := range map[string]int{"shards.log": 2, "heavy.log": 1} {
+		content, err := os.ReadFile(filepath.Join(tmpDir, log))
+		if err != nil {
+			t.Fatalf("read %s: %v", log, err)
+		}
+		running, peak := 0, 0
+		for _, line := range strings.Fields(string(content)) {
+			if line == "+" {
+				running++
+				peak = max(peak, running)
+			} else {
+				running--
+			}
+		}
+		if peak != want {
+			t.Errorf("%s peak concurrency = %d, want %d", log, peak, want)
+		}
+	}
+}
+
+func TestJobInterruptedWhileWaitingToStartRunsNoHooks(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	hooks := func() *Hooks {
+		return &Hooks{After: []*Action{NewAction(p.Shell, `touch "$JOB_NAME.after"`)}, Always: []*Action{NewAction(p.Shell, `touch "$JOB_NAME.always"`)}}
+	}
+	// 同一时间只能执行一个Job，Stage超时时另一个Job还在等待
+	test := NewStage("test", p, WithMaxParallel(1), WithStageTimeout(300*time.Millisecond))
+	test.AddJob(NewJob("first_job", []*Action{NewAction(p.Shell, "sleep 10")}, test, WithHooks(hooks())))
+	test.AddJob(NewJob("second_job", []*Action{NewAction(p.Shell, "sleep 10")}, test, WithHooks(hooks())))
+	p.AddStage(test)
+
+	result := p.Execute(context.Background())
+	var ran, waited []string
+	for _, job := range result.Stages[0].Jobs {
+		if job.Status != TimedOut {
+			t.Fatalf("job %s status = %s, want TimedOut", job.Name, job.Status)
+		}
+		if job.Actions[0].Status != Skiped {
+			ran = append(ran, job.Name)
+		} else {
+			waited = append(waited, job.Name)
+		}
+	}
+	if len(ran) != 1 || len(waited) != 1 {
+		t.Fatalf("jobs that ran = %v, jobs that waited = %v, want one of each", ran, waited)
+	}
+	for _, hook := range []string{"after", "always"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, ran[0]+"."+hook)); err != nil {
+			t.Errorf("%s hook of the running job did not run: %v", hook, err)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, waited[0]+"."+hook)); err == nil {
+			t.Errorf("%s hook of the waiting job ran, want not run", hook)
+		}
+	}
+}
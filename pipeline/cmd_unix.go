@@ -6,6 +6,8 @@ import (
 	"context"
 	"os/exec"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func GetDefaultShell() (cmd, flag string) {
@@ -21,5 +23,42 @@ func ShellCommand(shellCmd, shellFlag, name string, args ...string) *exec.Cmd {
 
 func ShellCommandContext(ctx context.Context, shellCmd, shellFlag, name string, args ...string) *exec.Cmd {
 	shellArgs := append([]string{name}, args...)
-	return exec.CommandContext(ctx, shellCmd, shellFlag, strings.Join(shellArgs, " "))
+	cmd := exec.CommandContext(ctx, shellCmd, shellFlag, strings.Join(shellArgs, " "))
+	stopProcessTree(cmd, stopPolicyFrom(ctx))
+	return cmd
+}
+
+var stopSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// stopProcessTree 让命令运行在独立的进程组中。
+// exec.CommandContext 默认只会杀死 shell 进程本身，shell 启动的孙子进程（如 cmake --build）会继续运行，
+// 因此这里在ctx结束时向整个进程组发送停止信号，超过宽限期后再发送 SIGKILL。
+func stopProcessTree(cmd *exec.Cmd, stop StopPolicy) {
+	sig, ok := stopSignals[stop.Signal]
+	if !ok {
+		sig = syscall.SIGTERM
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := -cmd.Process.Pid
+		err := syscall.Kill(pgid, sig)
+		if sig != syscall.SIGKILL {
+			// 即使shell已经退出，忽略停止信号的孙子进程仍然在进程组中，因此宽限期后总是要发送 SIGKILL。
+			// 进程组已经不存在时不再发送，避免进程组ID被复用后误杀无关的进程
+			time.AfterFunc(stop.GracePeriod, func() {
+				if syscall.Kill(pgid, 0) == nil {
+					syscall.Kill(pgid, syscall.SIGKILL)
+				}
+			})
+		}
+		return err
+	}
+	// 进程组中残留的进程可能一直持有输出管道，超过宽限期后不再等待管道关闭
+	cmd.WaitDelay = stop.GracePeriod
 }
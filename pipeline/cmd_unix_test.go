@@ -0,0 +1,93 @@
+//go:build !windows
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestJobTimeoutKillsProcessTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithStopPolicy(StopPolicy{Signal: "SIGTERM", GracePeriod: time.Second}))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job", []*Action{
+		NewAction(p.Shell, "sleep 30 & echo $! > child.pid; wait"),
+	}, build, WithTimeout(500*time.Millisecond)))
+	p.AddStage(build)
+
+	if status := p.Run(context.Background()); status != TimedOut {
+		t.Fatalf("Pipeline status = %s, want TimedOut", status)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "child.pid"))
+	if err != nil {
+		t.Fatalf("read child pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatalf("parse child pid %q: %v", content, err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("grandchild process %d is still running after job timeout", pid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestJobTimeoutKillsGrandchildIgnoringStopSignal(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithStopPolicy(StopPolicy{Signal: "SIGTERM", GracePeriod: 500 * time.Millisecond}))
+	build := NewStage("build", p)
+	// 孙子进程忽略 SIGTERM 且不持有输出管道，shell 收到 SIGTERM 后立即退出
+	build.AddJob(NewJob("build_job", []*Action{
+		NewAction(p.Shell, "(trap '' TERM; exec sleep 30) >/dev/null 2>&1 & echo $! > child.pid; sleep 30"),
+	}, build, WithTimeout(500*time.Millisecond)))
+	p.AddStage(build)
+
+	if status := p.Run(context.Background()); status != TimedOut {
+		t.Fatalf("Pipeline status = %s, want TimedOut", status)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "child.pid"))
+	if err != nil {
+		t.Fatalf("read child pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatalf("parse child pid %q: %v", content, err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("grandchild process %d ignoring SIGTERM is still running after the grace period", pid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// processAlive 判断进程是否还在运行。被杀死的孙子进程由init回收之前仍然是僵尸进程，此时认为它已经结束
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	// 格式为 "pid (comm) state ..."，comm中可能有空格，因此从最后一个右括号之后读取状态
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
@@ -5,6 +5,7 @@ package pipeline
 import (
 	"context"
 	"os/exec"
+	"strconv"
 )
 
 func GetDefaultShell() (cmd, flag string) {
@@ -20,5 +21,16 @@ func ShellCommand(shellCmd, shellFlag, name string, args ...string) *exec.Cmd {
 
 func ShellCommandContext(ctx context.Context, shellCmd, shellFlag, name string, args ...string) *exec.Cmd {
 	shellArgs := append([]string{shellFlag, name}, args...)
-	return exec.CommandContext(ctx, shellCmd, shellArgs...)
+	cmd := exec.CommandContext(ctx, shellCmd, shellArgs...)
+	stopProcessTree(cmd, stopPolicyFrom(ctx))
+	return cmd
+}
+
+// stopProcessTree 在ctx结束时结束命令启动的整个进程树。
+// Windows 上没有进程组信号，因此忽略 stop.Signal，直接使用 taskkill /T 结束子进程树。
+func stopProcessTree(cmd *exec.Cmd, stop StopPolicy) {
+	cmd.Cancel = func() error {
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	}
+	cmd.WaitDelay = stop.GracePeriod
 }
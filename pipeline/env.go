@@ -32,6 +32,10 @@ var Builtins = []*Env{
 		Name:        "PIPELINE_VERSION",
 		Description: "Current Pipeline version",
 	},
+	{
+		Name:        "PIPELINE_RUN_ID",
+		Description: "Unique ID of the current Pipeline run",
+	},
 	{
 		Name:        "PIPELINE_SHELL",
 		Description: "Current Pipeline shell",
@@ -44,14 +48,46 @@ var Builtins = []*Env{
 		Name:        "PIPELINE_WORKDIR",
 		Description: "Current Pipeline working directory",
 	},
+	{
+		Name:        "PIPELINE_STATUS",
+		Description: "Final status of the current Pipeline, only available in pipeline on_success, on_failure and always hooks",
+	},
 	{
 		Name:        "STAGE_NAME",
 		Description: "Current Stage name",
 	},
+	{
+		Name:        "STAGE_STATUS",
+		Description: "Final status of the current Stage, only available in stage on_success, on_failure and always hooks",
+	},
 	{
 		Name:        "JOB_NAME",
 		Description: "Current Job name",
 	},
+	{
+		Name:        "JOB_TAGS",
+		Description: "Comma separated tags of the current Job",
+	},
+	{
+		Name:        "JOB_STATUS",
+		Description: "Final status of the current Job, only available in on_success, on_failure and always hooks",
+	},
+	{
+		Name:        "JOB_FAILED_ACTION",
+		Description: "Action that failed or interrupted the current Job, only available in on_success, on_failure and always hooks",
+	},
+	{
+		Name:        "PIPELINE_OUTPUT",
+		Description: "File that actions append KEY=VALUE lines to, exported to later jobs when the job succeeds",
+	},
+	{
+		Name:        "ACTION_ATTEMPT",
+		Description: "Current Action attempt number, starting from 1",
+	},
+	{
+		Name:        "JOB_ATTEMPT",
+		Description: "Current Job attempt number when the whole Job is retried, starting from 1",
+	},
 	{
 		Name:        "OS",
 		Description: "Current OS",
@@ -70,80 +106,33 @@ var Builtins = []*Env{
 	},
 }
 
-func setupBuiltins(p *Pipeline) {
-	Builtins = []*Env{
-		{
-			Name:        "PIPELINE_NAME",
-			Value:       p.Name,
-			Description: "Current Pipeline name",
-		},
-		{
-			Name:        "PIPELINE_VERSION",
-			Value:       p.Version,
-			Description: "Current Pipeline version",
-		},
-		{
-			Name:        "PIPELINE_SHELL",
-			Value:       p.Shell[0],
-			Description: "Current Pipeline shell",
-		},
-		{
-			Name:        "PIPELINE_TIMESTAMP",
-			Value:       time.Now().Format("20060102150405"),
-			Description: "Current Pipeline starting timestamp in format 'YYYYMMDDHHMMSS'",
-		},
-		{
-			Name:        "PIPELINE_WORKDIR",
-			Value:       p.Workdir,
-			Description: "Current Pipeline working directory",
-		},
-		{
-			Name:        "STAGE_NAME",
-			Value:       "",
-			Description: "Current Stage name",
-		},
-		{
-			Name:        "JOB_NAME",
-			Value:       "",
-			Description: "Current Job name",
-		},
-		{
-			Name:        "OS",
-			Value:       runtime.GOOS,
-			Description: "Current OS",
-		},
-		{
-			Name:        "ARCH",
-			Value:       runtime.GOARCH,
-			Description: "Current Architecture",
-		},
-		{
-			Name:        "CPU_NUM",
-			Value:       strconv.Itoa(runtime.NumCPU()),
-			Description: "Number of logical CPU(s)",
-		},
-		{
-			Name:        "TEMP_DIR",
-			Value:       os.TempDir(),
-			Description: "Temporary directory",
-		},
-	}
-	for _, env := range Builtins {
-		// 必须用 Prepand ，这样才能确保内置变量放在p.Envs最前头，
-		// 从而使得自定义变量可以引用内置变量
-		p.Envs.Prepand(env.Name, env.Value)
+// builtinVars 返回流水线一次执行的内置变量的值。STAGE_NAME、JOB_NAME、JOB_TAGS、PIPELINE_OUTPUT、ACTION_ATTEMPT 和 JOB_ATTEMPT 随执行位置变化，在Job中注入
+func builtinVars(p *Pipeline, runID string) EnvList {
+	return EnvList{
+		{Key: "PIPELINE_NAME", Value: p.Name},
+		{Key: "PIPELINE_VERSION", Value: p.Version},
+		{Key: "PIPELINE_RUN_ID", Value: runID},
+		{Key: "PIPELINE_SHELL", Value: p.Shell[0]},
+		{Key: "PIPELINE_TIMESTAMP", Value: time.Now().Format("20060102150405")},
+		{Key: "PIPELINE_WORKDIR", Value: p.Workdir},
+		{Key: "OS", Value: runtime.GOOS},
+		{Key: "ARCH", Value: runtime.GOARCH},
+		{Key: "CPU_NUM", Value: strconv.Itoa(runtime.NumCPU())},
+		{Key: "TEMP_DIR", Value: os.TempDir()},
 	}
 }
 
-// 处理环境变量
-func resolveEnvList(shell [2]string, envs EnvList, bases ...EnvList) EnvList {
+// 处理环境变量。内联命令在本次执行的运行环境中执行，变量找不到时回退到运行环境中查找
+func resolveEnvList(env *runEnv, shell [2]string, envs EnvList, bases ...EnvList) EnvList {
 	resolved := EnvList{}
-	for _, env := range envs {
-		key := env.Key
-		value := env.Value
+	for _, item := range envs {
+		key := item.Key
+		value := item.Value
 		// 1. 执行value中可能包含的$变量以及`命令`
 		cmds := findInlineCmd(value, shell)
 		for _, cmd := range cmds {
+			cmd.cmd.Env = env.environ()
+			cmd.cmd.Dir = env.dir()
 			output, err := cmd.cmd.CombinedOutput()
 			if err != nil {
 				slog.Error(fmt.Sprintf("failed to expr %s: %v", cmd.cmd.String(), err), "error", err, "expr", cmd.cmd.String())
@@ -162,11 +151,8 @@ func resolveEnvList(shell [2]string, envs EnvList, bases ...EnvList) EnvList {
 					return val
 				}
 			}
-			// 最后再回退到环境变量
-			if val := os.Getenv(v); val != "" {
-				return val
-			}
-			return ""
+			// 最后再回退到运行环境中的变量
+			return env.getenv(v)
 		})
 		resolved.Append(key, value)
 	}
@@ -1,7 +1,10 @@
 package pipeline
 
 import (
+	"context"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -24,3 +27,27 @@ func TestFindInlineCmdUsesConfiguredShell(t *testing.T) {
 		t.Fatalf("output = %q, want ok", output)
 	}
 }
+
+func TestMatrixVarsAreInjectedIntoJob(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job-Release", []*Action{
+		NewAction(p.Shell, "printf '%s' \"$OUT_DIR\" > matrix.out"),
+	}, build, WithMatrix(EnvList{{Key: "BUILD_TYPE", Value: "Release"}}), WithJobEnvs(EnvList{
+		{Key: "OUT_DIR", Value: "build/$BUILD_TYPE"},
+	})))
+	p.AddStage(build)
+
+	if status := p.Run(context.Background()); status != Success {
+		t.Fatalf("Pipeline status = %s, want Success", status)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "matrix.out"))
+	if err != nil {
+		t.Fatalf("read matrix output: %v", err)
+	}
+	if string(got) != "build/Release" {
+		t.Fatalf("matrix output = %q, want build/Release", got)
+	}
+}
@@ -11,18 +11,21 @@ func TestJobDoesNotImportExportsBeforeStageCompletes(t *testing.T) {
 	tmpDir := t.TempDir()
 	t.Setenv("BUILD_DIR", "")
 
-	p := NewPipeline("test", "1.0.0", WithWorkdir(tmpDir))
+	p := mustNewPipeline(t, "test", "1.0.0", WithWorkdir(tmpDir))
 	s := NewStage("build", p)
-	j := NewJob("build_job", nil, s, WithExports(EnvList{{Key: "BUILD_DIR", Value: "build/release"}}))
-
-	s.wg.Add(1)
-	go j.Do(context.Background())
-	if status := <-j.Result(); status != Success {
+	s.AddJob(NewJob("build_job", nil, s, WithExports(EnvList{{Key: "BUILD_DIR", Value: "build/release"}})))
+	p.AddStage(s)
+
+	r := p.NewRun()
+	j := r.stages[0].jobs[0]
+	j.s.wg.Add(1)
+	go j.do(context.Background())
+	if status := <-j.resCh; status != Success {
 		t.Fatalf("Job status = %s, want Success", status)
 	}
-	s.wg.Wait()
+	j.s.wg.Wait()
 
-	if got := os.Getenv("BUILD_DIR"); got != "" {
+	if got := r.env.getenv("BUILD_DIR"); got != "" {
 		t.Fatalf("BUILD_DIR = %q, want empty before stage import", got)
 	}
 }
@@ -34,7 +37,7 @@ func TestStageImportsExportsAfterSuccess(t *testing.T) {
 		t.Setenv(key, "")
 	}
 
-	p := NewPipeline("test", "1.0.0", WithWorkdir(tmpDir))
+	p := mustNewPipeline(t, "test", "1.0.0", WithWorkdir(tmpDir))
 	s := NewStage("build", p)
 	s.AddJob(NewJob("build_job", nil, s, WithExports(EnvList{
 		{Key: "BUILD_DIR", Value: "build/release"},
@@ -42,21 +45,32 @@ func TestStageImportsExportsAfterSuccess(t *testing.T) {
 		{Key: "EMPTY", Value: ""},
 	})))
 
-	if status := s.Perform(context.Background()); status != Success {
+	status, env := performStage(p, s)
+	if status != Success {
 		t.Fatalf("Stage status = %s, want Success", status)
 	}
 
-	if got := os.Getenv("BUILD_DIR"); got != "build/release" {
+	if got := env.getenv("BUILD_DIR"); got != "build/release" {
 		t.Fatalf("BUILD_DIR = %q, want build/release", got)
 	}
-	if got := os.Getenv("BUILD_VERSION"); got != "1.2.3" {
+	if got := env.getenv("BUILD_VERSION"); got != "1.2.3" {
 		t.Fatalf("BUILD_VERSION = %q, want 1.2.3", got)
 	}
-	if got := os.Getenv("EMPTY"); got != "" {
-		t.Fatalf("EMPTY = %q, want empty", got)
+	if got, ok := env.lookup("EMPTY"); !ok || got != "" {
+		t.Fatalf("EMPTY = %q (set: %v), want empty", got, ok)
+	}
+	if got := os.Getenv("BUILD_DIR"); got != "" {
+		t.Fatalf("BUILD_DIR leaked to process env: %q", got)
 	}
 }
 
+// performStage 在流水线的一次新的执行中单独执行Stage，返回Stage的状态以及执行后的运行环境
+func performStage(p *Pipeline, s *Stage) (Status, *runEnv) {
+	p.AddStage(s)
+	r := p.NewRun()
+	return r.stages[len(r.stages)-1].perform(context.Background()), r.env
+}
+
 func restoreWdAfterTest(t *testing.T) {
 	t.Helper()
 	oldWd, err := os.Getwd()
@@ -79,15 +93,16 @@ func TestStageDoesNotImportExportsAfterFailure(t *testing.T) {
 	restoreWdAfterTest(t)
 	t.Setenv("SHOULD_NOT_IMPORT", "")
 
-	p := NewPipeline("test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
 	s := NewStage("build", p)
 	s.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "exit 1")}, s, WithExports(EnvList{{Key: "SHOULD_NOT_IMPORT", Value: "yes"}})))
 
-	if status := s.Perform(context.Background()); status != Failed {
+	status, env := performStage(p, s)
+	if status != Failed {
 		t.Fatalf("Stage status = %s, want Failed", status)
 	}
 
-	if got := os.Getenv("SHOULD_NOT_IMPORT"); got != "" {
+	if got := env.getenv("SHOULD_NOT_IMPORT"); got != "" {
 		t.Fatalf("SHOULD_NOT_IMPORT = %q, want empty", got)
 	}
 }
@@ -103,21 +118,66 @@ func TestStageResolvesExportValuesAfterSuccess(t *testing.T) {
 	t.Setenv("RELEASE", "")
 	t.Setenv("COMMAND_VALUE", "")
 
-	p := NewPipeline("test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
 	s := NewStage("build", p)
 	s.AddJob(NewJob("build_job", nil, s, WithExports(EnvList{
 		{Key: "RELEASE", Value: "$BASE_VERSION/release"},
 		{Key: "COMMAND_VALUE", Value: "$(printf export-ok)"},
 	})))
 
-	if status := s.Perform(context.Background()); status != Success {
+	status, env := performStage(p, s)
+	if status != Success {
 		t.Fatalf("Stage status = %s, want Success", status)
 	}
 
-	if got := os.Getenv("RELEASE"); got != "1.2.3/release" {
+	if got := env.getenv("RELEASE"); got != "1.2.3/release" {
 		t.Fatalf("RELEASE = %q, want 1.2.3/release", got)
 	}
-	if got := os.Getenv("COMMAND_VALUE"); got != "export-ok" {
+	if got := env.getenv("COMMAND_VALUE"); got != "export-ok" {
 		t.Fatalf("COMMAND_VALUE = %q, want export-ok", got)
 	}
 }
+
+func TestStageImportsValuesWrittenToOutputFile(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()))
+	s := NewStage("build", p)
+	s.AddJob(NewJob("build_job", []*Action{
+		NewAction(p.Shell, `echo "VERSION=1.2.$((1+2))" >> "$PIPELINE_OUTPUT"`),
+		NewAction(p.Shell, `printf 'NOTES<<EOF\nline 1\nline 2\nEOF\n' >> "$PIPELINE_OUTPUT"`),
+	}, s, WithExports(EnvList{{Key: "ARTIFACT", Value: "app-$VERSION.tar.gz"}})))
+
+	status, env := performStage(p, s)
+	if status != Success {
+		t.Fatalf("Stage status = %s, want Success", status)
+	}
+
+	for key, want := range map[string]string{
+		"VERSION":  "1.2.3",
+		"NOTES":    "line 1\nline 2",
+		"ARTIFACT": "app-1.2.3.tar.gz",
+	} {
+		if got := env.getenv(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestJobFailsOnMalformedOutputFile(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()))
+	s := NewStage("build", p)
+	s.AddJob(NewJob("build_job", []*Action{
+		NewAction(p.Shell, `echo "not a variable" >> "$PIPELINE_OUTPUT"`),
+	}, s))
+
+	if status, _ := performStage(p, s); status != Failed {
+		t.Fatalf("Stage status = %s, want Failed", status)
+	}
+}
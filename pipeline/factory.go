@@ -1,9 +1,9 @@
 package pipeline
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 	"slices"
 	"time"
 
@@ -14,32 +14,80 @@ func isSkipped(config *parser.PipelineConf, item string) bool {
 	return slices.Contains(config.Skips, item)
 }
 
-// MakePipeline 根据配置信息创建流水线
-func MakePipeline(config *parser.PipelineConf) *Pipeline {
+// MakePipeline 根据配置信息创建流水线，opts在配置之后应用。配置中存在多个问题时，返回的错误包含全部问题
+func MakePipeline(config *parser.PipelineConf, opts ...PipelineOptions) (*Pipeline, error) {
 	// 创建流水线
-	pipeObj := NewPipeline(config.Name, config.Version, WithShell(config.Shell), WithCron(config.Cron), WithEnvs(config.Envs), WithWorkdir(config.Workdir))
+	pipeOpts := []PipelineOptions{WithShell(config.Shell), WithCron(config.Cron), WithEnvs(config.Envs), WithWorkdir(config.Workdir), WithStopPolicy(makeStopPolicy(config)), WithFailFast(config.FailFast), WithLockDir(config.LockDir), WithPipelineTimeout(parseTimeout("pipeline", config.Timeout))}
+	pipeObj, err := NewPipeline(config.Name, config.Version, append(pipeOpts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+
+	// 创建流水线的hooks，需要使用 NewPipeline 解析出的shell
+	if hooks, err := makeHooks(pipeObj.Shell, config.Hooks); err != nil {
+		errs = append(errs, fmt.Errorf("pipeline: %w", err))
+	} else {
+		pipeObj.Hooks = hooks
+	}
 
 	// 为每个阶段创建 Stage 对象
 	stageMap := make(map[string]*Stage)
-	for _, stageName := range config.Stages {
+	for _, stage := range config.Stages {
+		stageName := stage.Name
 		if isSkipped(config, stageName) {
 			continue
 		}
-		stageObj, exists := stageMap[stageName]
-		if exists {
-			pipeObj.logger.Error(fmt.Sprintf("duplicate stage %q", stageName), "stage", stageName)
-			os.Exit(1)
+		if _, exists := stageMap[stageName]; exists {
+			errs = append(errs, fmt.Errorf("duplicate stage %q", stageName))
+			continue
+		}
+		when, err := ParseWhen(stage.When)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stage %s: %w", stageName, err))
+			continue
+		}
+		hooks, err := makeHooks(pipeObj.Shell, stage.Hooks)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stage %s: %w", stageName, err))
+			continue
+		}
+		stageOpts := []StageOptions{WithWhen(when), WithStageHooks(hooks), WithMaxParallel(stage.MaxParallel), WithStageTimeout(parseTimeout("stage "+stageName, stage.Timeout))}
+		if stage.FailFast != nil {
+			stageOpts = append(stageOpts, WithStageFailFast(*stage.FailFast))
 		}
-		stageObj = NewStage(stageName, pipeObj)
+		stageObj := NewStage(stageName, pipeObj, stageOpts...)
 		stageMap[stageName] = stageObj
 		pipeObj.AddStage(stageObj)
 	}
 
+	// 预先展开矩阵Job的变体名，使得needs一个矩阵Job时可以依赖其全部变体
+	variants := make(map[string][]matrixVariant)
+	for jobName, jobDef := range config.Jobs {
+		if jobDef.Matrix != nil {
+			variants[jobName] = expandMatrix(jobName, jobDef.Matrix.Combinations())
+		}
+	}
+
+	// Job名在整个流水线中必须唯一，包括矩阵Job展开后的变体
+	duplicated := make(map[string]bool)
+	for _, dup := range parser.FindDuplicateJobNames(config) {
+		errs = append(errs, fmt.Errorf("duplicate job name %q: used by both job %s and job %s", dup.Name, dup.Other, dup.Job))
+		duplicated[dup.Name] = true
+	}
+
 	// 处理Job
+	filteredStages := make(map[*Stage]bool) // 有Job因为tags没有被选中的Stage
 	for jobName, jobDef := range config.Jobs {
 		if parser.IsKeyword(jobName) || isSkipped(config, jobName) || isSkipped(config, jobDef.Stage) {
 			continue
 		}
+		if !pipeObj.tagFilter.Match(jobDef.Tags) {
+			if stageObj, exists := stageMap[jobDef.Stage]; exists {
+				filteredStages[stageObj] = true
+			}
+			continue
+		}
 
 		// 检查对应的Stage是否存在
 		stageObj, exists := stageMap[jobDef.Stage]
@@ -49,34 +97,205 @@ func MakePipeline(config *parser.PipelineConf) *Pipeline {
 			continue
 		}
 
-		// 创建Job并添加到Stage
-		// 1. 创建Actions并添加到Job
-		actions := makeActions(pipeObj.Shell, jobDef.Actions)
-		// 2. 创建Hooks并添加到Job
-		hooks := &Hooks{
-			Before: makeActions(pipeObj.Shell, jobDef.Hooks.Before),
-			After:  makeActions(pipeObj.Shell, jobDef.Hooks.After),
+		needs := expandNeeds(jobDef.Needs, variants)
+		jobVariants := []matrixVariant{{name: jobName}}
+		if jobDef.Matrix != nil {
+			jobVariants = variants[jobName]
+			if len(jobVariants) == 0 {
+				slog.Warn(fmt.Sprintf("matrix of job %s has no combinations, ignored it", jobName), "job", jobName)
+			}
 		}
-		jobObj := NewJob(jobName, actions, stageObj, WithAllowFailure(jobDef.AllowFailure), WithJobEnvs(jobDef.Envs), WithRules(jobDef.Rules), WithExports(jobDef.Exports), WithHooks(hooks))
-		if jobDef.Timeout != "" {
-			if jobTimeout, err := time.ParseDuration(jobDef.Timeout); err == nil {
-				jobObj.Timeout = jobTimeout
+		// 创建Job并添加到Stage。矩阵Job的每个变体都需要独立的Action对象，因为Action上保存了各自Job注入的环境变量
+		for _, variant := range jobVariants {
+			if duplicated[variant.name] {
+				continue
+			}
+			// 1. 创建Actions并添加到Job
+			actions, err := makeJobActions(pipeObj.Shell, jobName, jobDef.Actions)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("job %s: %w", variant.name, err))
+				continue
+			}
+			// 2. 创建Hooks并添加到Job
+			hooks, err := makeHooks(pipeObj.Shell, jobDef.Hooks)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("job %s: %w", variant.name, err))
+				continue
 			}
+			jobObj := NewJob(variant.name, actions, stageObj, WithAllowFailure(jobDef.AllowFailure), WithJobEnvs(jobDef.Envs), WithRules(jobDef.Rules), WithExports(jobDef.Exports), WithHooks(hooks), WithNeeds(needs), WithMatrix(variant.vars), WithRetry(makeRetry(jobName, jobDef.Retry)), WithResourceGroup(jobDef.ResourceGroup), WithTags(jobDef.Tags))
+			if jobDef.Weight > 0 {
+				jobObj.Weight = jobDef.Weight
+			}
+			if jobDef.Timeout != "" {
+				if jobTimeout, err := time.ParseDuration(jobDef.Timeout); err == nil {
+					jobObj.Timeout = jobTimeout
+				}
+			}
+			if err := stageObj.AddJob(jobObj); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	// 全部Job都没有被tags选中的Stage不再执行，也就不会执行其hooks；但 when: always 的Stage（例如清理）仍然执行
+	pipeObj.Stages = slices.DeleteFunc(pipeObj.Stages, func(stage *Stage) bool {
+		return filteredStages[stage] && len(stage.Jobs) == 0 && stage.When != Always
+	})
+	if len(filteredStages) > 0 && !slices.ContainsFunc(pipeObj.Stages, func(stage *Stage) bool { return len(stage.Jobs) > 0 }) {
+		return nil, errors.New("no jobs selected by tags")
+	}
+	return pipeObj, nil
+}
+
+// makeHooks 根据配置创建hooks，返回的错误包含全部有问题的hooks
+func makeHooks(shell [2]string, conf parser.HooksConf) (*Hooks, error) {
+	hooks := &Hooks{}
+	var errs []error
+	for _, hook := range []struct {
+		name    string
+		cmds    []string
+		actions *[]*Action
+	}{
+		{"before", conf.Before, &hooks.Before},
+		{"after", conf.After, &hooks.After},
+		{"on_success", conf.OnSuccess, &hooks.OnSuccess},
+		{"on_failure", conf.OnFailure, &hooks.OnFailure},
+		{"always", conf.Always, &hooks.Always},
+	} {
+		actions, err := makeActions(shell, hook.cmds)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s hooks: %w", hook.name, err))
+			continue
+		}
+		*hook.actions = actions
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return hooks, nil
+}
+
+func makeStopPolicy(config *parser.PipelineConf) StopPolicy {
+	stop := StopPolicy{Signal: DefaultStopSignal, GracePeriod: DefaultStopGracePeriod}
+	if config.StopSignal != "" {
+		stop.Signal = config.StopSignal
+	}
+	if config.StopGracePeriod != "" {
+		gracePeriod, err := time.ParseDuration(config.StopGracePeriod)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("invalid stop grace period %q, use %v instead", config.StopGracePeriod, DefaultStopGracePeriod), "stop_grace_period", config.StopGracePeriod, "error", err)
+		} else {
+			stop.GracePeriod = gracePeriod
+		}
+	}
+	return stop
+}
+
+// parseTimeout 解析Pipeline或Stage的timeout，为空或者无效时表示不限制
+func parseTimeout(unit, timeout string) time.Duration {
+	if timeout == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("invalid timeout %q of %s, ignored it", timeout, unit), "timeout", timeout, "error", err)
+		return 0
+	}
+	return d
+}
+
+func makeRetry(jobName string, conf *parser.RetryConf) *RetryPolicy {
+	if conf == nil || conf.Max <= 0 {
+		return nil
+	}
+	retry := &RetryPolicy{
+		Max:         conf.Max,
+		Backoff:     defaultRetryBackoff,
+		OnExitCodes: conf.OnExitCodes,
+		WholeJob:    conf.Scope == parser.RetryScopeJob,
+	}
+	if conf.Backoff != "" {
+		backoff, err := time.ParseDuration(conf.Backoff)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("invalid retry backoff %q of job %s, use %v instead", conf.Backoff, jobName, defaultRetryBackoff), "job", jobName, "backoff", conf.Backoff, "error", err)
+		} else {
+			retry.Backoff = backoff
+		}
+	}
+	return retry
+}
+
+// matrixVariant 矩阵Job展开后的一个变体
+type matrixVariant struct {
+	name string
+	vars EnvList
+}
+
+// expandMatrix 将矩阵组合转换为Job变体，变体名见 parser.MatrixJobName
+func expandMatrix(jobName string, combos []EnvList) []matrixVariant {
+	result := make([]matrixVariant, 0, len(combos))
+	for _, combo := range combos {
+		result = append(result, matrixVariant{name: parser.MatrixJobName(jobName, combo), vars: combo})
+	}
+	return result
+}
+
+// expandNeeds 将needs中引用的矩阵Job替换为其全部变体
+func expandNeeds(needs []string, variants map[string][]matrixVariant) []string {
+	if needs == nil {
+		return nil
+	}
+	result := make([]string, 0, len(needs))
+	for _, need := range needs {
+		jobVariants, isMatrix := variants[need]
+		if !isMatrix {
+			result = append(result, need)
+			continue
+		}
+		for _, variant := range jobVariants {
+			result = append(result, variant.name)
 		}
-		stageObj.AddJob(jobObj)
 	}
+	return result
+}
 
-	return pipeObj
+// makeJobActions 创建Job的Actions，并设置Action上覆盖Job的重试策略
+func makeJobActions(shell [2]string, jobName string, confs []parser.ActionConf) ([]*Action, error) {
+	lines := make([]string, 0, len(confs))
+	for _, conf := range confs {
+		lines = append(lines, conf.Run)
+	}
+	actions, err := makeActions(shell, lines)
+	if err != nil {
+		return nil, err
+	}
+	for i, conf := range confs {
+		if conf.Retry == nil {
+			continue
+		}
+		// retry: 0 表示该Action不重试
+		actions[i].Retry = makeRetry(jobName, conf.Retry)
+		if actions[i].Retry == nil {
+			actions[i].Retry = &RetryPolicy{}
+		}
+		actions[i].Retry.WholeJob = false
+	}
+	return actions, nil
 }
 
-func makeActions(shell [2]string, actionLines []string) (actions []*Action) {
+func makeActions(shell [2]string, actionLines []string) (actions []*Action, err error) {
 	for _, actionLine := range actionLines {
 		var action *Action
 		if shell[0] == "cmd" {
 			actionArgs, err := makeSafeCmdline(actionLine)
+			if err == nil && len(actionArgs) == 0 {
+				err = errors.New("empty command")
+			}
 			if err != nil {
-				slog.Error(fmt.Sprintf("invalid action format: %s (error: %v)", actionLine, err), "error", err, "action", actionLine)
-				os.Exit(-1)
+				return nil, fmt.Errorf("invalid action format: %s (error: %w)", actionLine, err)
 			}
 			if len(actionArgs) > 1 {
 				action = NewAction(shell, actionArgs[0], actionArgs[1:]...)
@@ -88,7 +307,7 @@ func makeActions(shell [2]string, actionLines []string) (actions []*Action) {
 		}
 		actions = append(actions, action)
 	}
-	return
+	return actions, nil
 }
 
 // 使用'单引号包裹一个带有空格的命令。
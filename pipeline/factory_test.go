@@ -1,17 +1,22 @@
 package pipeline
 
 import (
+	"context"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Meha555/go-pipeline/parser"
 )
 
 func TestMakeActionsUsesSafeCmdlineForCmd(t *testing.T) {
 	shell := [2]string{"cmd", "/c"}
-	actions := makeActions(shell, []string{"'C:\\Program Files\\LLVM\\bin\\clang++.exe' --version"})
+	actions, err := makeActions(shell, []string{"'C:\\Program Files\\LLVM\\bin\\clang++.exe' --version"})
+	if err != nil {
+		t.Fatalf("makeActions() error = %v", err)
+	}
 	if len(actions) != 1 {
 		t.Fatalf("len(actions) = %d, want 1", len(actions))
 	}
@@ -29,7 +34,10 @@ func TestMakeActionsUsesSafeCmdlineForCmd(t *testing.T) {
 func TestMakeActionsKeepsRawLineForShells(t *testing.T) {
 	for _, shell := range [][2]string{{"sh", "-c"}, {"bash", "-c"}, {"powershell", "-c"}} {
 		t.Run(shell[0], func(t *testing.T) {
-			actions := makeActions(shell, []string{"'C:\\Program Files\\LLVM\\bin\\clang++.exe' --version"})
+			actions, err := makeActions(shell, []string{"'C:\\Program Files\\LLVM\\bin\\clang++.exe' --version"})
+			if err != nil {
+				t.Fatalf("makeActions() error = %v", err)
+			}
 			if len(actions) != 1 {
 				t.Fatalf("len(actions) = %d, want 1", len(actions))
 			}
@@ -69,7 +77,10 @@ build_job:
 		t.Fatalf("ParseConfigFile() error = %v", err)
 	}
 
-	pipe := MakePipeline(conf)
+	pipe, err := MakePipeline(conf)
+	if err != nil {
+		t.Fatalf("MakePipeline() error = %v", err)
+	}
 	if len(pipe.Stages) != 1 || len(pipe.Stages[0].Jobs) != 1 {
 		t.Fatalf("pipeline shape = %d stages, want one stage with one job", len(pipe.Stages))
 	}
@@ -80,25 +91,146 @@ build_job:
 }
 
 func TestMakePipelineRejectsDuplicateStageName(t *testing.T) {
-	if os.Getenv("TEST_DUPLICATE_STAGE") == "1" {
-		tmpDir := t.TempDir()
-		configPath := filepath.Join(tmpDir, "pipeline.yaml")
-		config := []byte(`name: test
+	conf := &parser.PipelineConf{
+		Name:    "test",
+		Version: "1.0.0",
+		Workdir: t.TempDir(),
+		Stages:  []parser.StageConf{{Name: "build"}, {Name: "build"}},
+	}
+
+	pipe, err := MakePipeline(conf)
+	if err == nil || pipe != nil || !strings.Contains(err.Error(), `duplicate stage "build"`) {
+		t.Fatalf("MakePipeline() = %v, %v, want duplicate stage error", pipe, err)
+	}
+}
+
+func TestMakePipelineReportsAllProblems(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "pipeline.yaml")
+	config := []byte(`name: test
 version: 1.0.0
+shell: cmd
+workdir: ` + tmpDir + `
 stages:
   - build
+build_job:
+  stage: build
+  matrix:
+    BUILD_TYPE: Debug
+  actions:
+    - echo build
+lint_job:
+  stage: build
+  actions:
+    - " "
+`)
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	conf, err := parser.ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	// 绕过配置校验，直接构造有问题的配置
+	conf.Stages = append(conf.Stages, parser.StageConf{Name: "build"})
+	duplicate := conf.Jobs["build_job"]
+	duplicate.Matrix = nil
+	conf.Jobs["build_job-Debug"] = duplicate
+
+	pipe, err := MakePipeline(conf)
+	if pipe != nil || err == nil {
+		t.Fatalf("MakePipeline() = %v, %v, want error", pipe, err)
+	}
+	for _, want := range []string{`duplicate stage "build"`, `duplicate job name "build_job-Debug": used by both job build_job and job build_job-Debug`, "job lint_job: invalid action format"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("MakePipeline() error = %v, want %q", err, want)
+		}
+	}
+
+	conf.Shell = "zsh"
+	if _, err := MakePipeline(conf); err == nil || !strings.Contains(err.Error(), `unknown shell "zsh"`) {
+		t.Fatalf("MakePipeline() error = %v, want unknown shell error", err)
+	}
+}
+
+func TestMakePipelineRejectsDuplicateJobNamesAcrossStages(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "pipeline.yaml")
+	config := []byte(`name: test
+version: 1.0.0
+stages:
+  - a
+  - b
+build:
+  stage: a
+  matrix:
+    os: [linux, windows]
+  actions:
+    - echo build
+build-linux:
+  stage: b
+  actions:
+    - echo linux
+`)
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	conf, problems, err := parser.ValidateConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() error = %v", err)
+	}
+	if len(problems) != 1 || problems[0].Path != "build-linux" || !strings.Contains(problems[0].Message, `duplicate job name "build-linux": used by both job build and job build-linux`) {
+		t.Fatalf("ValidateConfigFile() problems = %v, want duplicate job name", problems)
+	}
+
+	if pipe, err := MakePipeline(conf); pipe != nil || err == nil || !strings.Contains(err.Error(), `duplicate job name "build-linux"`) {
+		t.Fatalf("MakePipeline() = %v, %v, want duplicate job name error", pipe, err)
+	}
+}
+
+func TestMakePipelineAppliesRetryScopeAndActionRetry(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "pipeline.yaml")
+	config := []byte(`name: test
+version: 1.0.0
+stages:
   - build
+build_job:
+  stage: build
+  retry:
+    max: 2
+    scope: job
+  actions:
+    - make
+    - run: curl -fsSL https://example.com -o archive.tar.gz
+      retry:
+        max: 3
+        backoff: 2s
+    - run: make install
+      retry: 0
 `)
-		os.WriteFile(configPath, config, 0o600)
-		conf, _ := parser.ParseConfigFile(configPath)
-		MakePipeline(conf)
-		return
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	conf, err := parser.ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	pipe, err := MakePipeline(conf)
+	if err != nil {
+		t.Fatalf("MakePipeline() error = %v", err)
 	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestMakePipelineRejectsDuplicateStageName")
-	cmd.Env = append(os.Environ(), "TEST_DUPLICATE_STAGE=1")
-	err := cmd.Run()
-	if err == nil {
-		t.Fatalf("expected process to exit with non-zero status")
+	job := pipe.Stages[0].Jobs[0]
+	if job.Retry == nil || !job.Retry.WholeJob || job.Retry.Max != 2 {
+		t.Fatalf("job retry = %+v, want whole job retry", job.Retry)
+	}
+	actions := job.Actions
+	if actions[0].Retry != nil {
+		t.Fatalf("action 0 retry = %+v, want job retry", actions[0].Retry)
+	}
+	if r := actions[1].Retry; r == nil || r.Max != 3 || r.Backoff != 2*time.Second || r.WholeJob {
+		t.Fatalf("action 1 retry = %+v, want its own retry", r)
+	}
+	if r := actions[2].Retry; r == nil || r.Max != 0 {
+		t.Fatalf("action 2 retry = %+v, want no retry", r)
 	}
 }
 
@@ -125,7 +257,10 @@ build_job:
 		t.Fatalf("ParseConfigFile() error = %v", err)
 	}
 
-	pipe := MakePipeline(conf)
+	pipe, err := MakePipeline(conf)
+	if err != nil {
+		t.Fatalf("MakePipeline() error = %v", err)
+	}
 	if len(pipe.Stages) != 1 || len(pipe.Stages[0].Jobs) != 1 {
 		t.Fatalf("pipeline shape = %d stages, want one stage with one job", len(pipe.Stages))
 	}
@@ -134,3 +269,192 @@ build_job:
 		t.Fatalf("Envs = %#v, want job envs", got)
 	}
 }
+
+func TestMakePipelineExpandsMatrixJobs(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "pipeline.yaml")
+	config := []byte(`name: test
+version: 1.0.0
+stages:
+  - build
+  - test
+build_job:
+  stage: build
+  matrix:
+    BUILD_TYPE: [Debug, Release]
+  actions:
+    - echo $BUILD_TYPE
+test_job:
+  stage: test
+  needs: [build_job]
+  actions:
+    - echo ok
+`)
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	conf, err := parser.ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+
+	pipe, err := MakePipeline(conf)
+	if err != nil {
+		t.Fatalf("MakePipeline() error = %v", err)
+	}
+	jobs := pipe.Stages[0].Jobs
+	if len(jobs) != 2 || jobs[0].Name != "build_job-Debug" || jobs[1].Name != "build_job-Release" {
+		t.Fatalf("matrix jobs = %d, want build_job-Debug and build_job-Release", len(jobs))
+	}
+	if value, _ := jobs[1].Matrix.Find("BUILD_TYPE"); value != "Release" {
+		t.Fatalf("build_job-Release BUILD_TYPE = %q, want Release", value)
+	}
+	if jobs[0].Actions[0] == jobs[1].Actions[0] {
+		t.Fatalf("matrix variants share the same action object")
+	}
+	needs := pipe.Stages[1].Jobs[0].Needs
+	if len(needs) != 2 || needs[0] != "build_job-Debug" || needs[1] != "build_job-Release" {
+		t.Fatalf("test_job.Needs = %#v, want all build_job variants", needs)
+	}
+}
+
+func TestPipelineAndStageHooksRunAroundTheirUnits(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "pipeline.yaml")
+	config := []byte(`name: test
+version: 1.0.0
+shell: sh
+workdir: ` + tmpDir + `
+hooks:
+  before:
+    - echo pipeline-before >> order.log
+  after:
+    - echo pipeline-after >> order.log
+  on_failure:
+    - echo "pipeline-on_failure $PIPELINE_STATUS" >> order.log
+  on_success:
+    - echo pipeline-on_success >> order.log
+  always:
+    - echo "pipeline-always $PIPELINE_STATUS" >> order.log
+stages:
+  - name: build
+    hooks:
+      before:
+        - echo "stage-before $STAGE_NAME" >> order.log
+        - exit 1
+        - echo never >> order.log
+      after:
+        - echo stage-after >> order.log
+      on_failure:
+        - echo "stage-on_failure $STAGE_STATUS" >> order.log
+build_job:
+  stage: build
+  actions:
+    - echo job >> order.log
+    - exit 2
+`)
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	conf, err := parser.ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	pipe, err := MakePipeline(conf)
+	if err != nil {
+		t.Fatalf("MakePipeline() error = %v", err)
+	}
+
+	if status := pipe.Run(context.Background()); status != Failed {
+		t.Fatalf("Pipeline status = %s, want Failed", status)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "order.log"))
+	if err != nil {
+		t.Fatalf("read hooks output: %v", err)
+	}
+	want := `pipeline-before
+stage-before build
+job
+stage-after
+stage-on_failure Failed
+pipeline-after
+pipeline-on_failure Failed
+pipeline-always Failed
+`
+	if string(got) != want {
+		t.Fatalf("hooks output = %q, want %q", got, want)
+	}
+}
+
+func TestMakePipelineFiltersJobsByTags(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "pipeline.yaml")
+	config := []byte(`name: test
+version: 1.0.0
+shell: sh
+workdir: ` + tmpDir + `
+stages:
+  - check
+  - integration
+  - name: cleanup
+    when: always
+    hooks:
+      always:
+        - touch cleanup.done
+lint_job:
+  stage: check
+  tags: [quick, lint]
+  actions:
+    - printf '%s' "$JOB_TAGS" > lint.tags
+unit_job:
+  stage: check
+  tags: [quick, unit, slow]
+  actions:
+    - echo unit
+integration_job:
+  stage: integration
+  tags: [slow]
+  actions:
+    - echo integration
+`)
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	conf, err := parser.ParseConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("ParseConfigFile() error = %v", err)
+	}
+	filter, err := ParseTagFilter("quick", "slow")
+	if err != nil {
+		t.Fatalf("ParseTagFilter() error = %v", err)
+	}
+
+	pipe, err := MakePipeline(conf, WithTagFilter(filter))
+	if err != nil {
+		t.Fatalf("MakePipeline() error = %v", err)
+	}
+	if len(pipe.Stages) != 2 || len(pipe.Stages[0].Jobs) != 1 || pipe.Stages[0].Jobs[0].Name != "lint_job" || pipe.Stages[1].Name != "cleanup" {
+		t.Fatalf("pipeline has %d stages, want check stage with lint_job and the cleanup stage", len(pipe.Stages))
+	}
+	if status := pipe.Run(context.Background()); status != Success {
+		t.Fatalf("Pipeline status = %s, want Success", status)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "lint.tags"))
+	if err != nil {
+		t.Fatalf("read JOB_TAGS output: %v", err)
+	}
+	if string(got) != "quick,lint" {
+		t.Fatalf("JOB_TAGS = %q, want quick,lint", got)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "cleanup.done")); err != nil {
+		t.Fatalf("cleanup stage hooks did not run: %v", err)
+	}
+
+	filter, err = ParseTagFilter("docker", "")
+	if err != nil {
+		t.Fatalf("ParseTagFilter() error = %v", err)
+	}
+	if _, err := MakePipeline(conf, WithTagFilter(filter)); err == nil || err.Error() != "no jobs selected by tags" {
+		t.Fatalf("MakePipeline() error = %v, want no jobs selected by tags", err)
+	}
+}
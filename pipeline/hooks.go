@@ -1,12 +1,20 @@
 package pipeline
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
 
-// Hooks 是 Job 的钩子函数，可以指定在某个job之前或之后执行（不论job是否失败）。
-// 其本身允许失败，失败时只是终止同级的剩余hooks执行，不会影响所在job的执行。
+// Hooks 是 Pipeline、Stage 或 Job 的钩子函数，可以指定在其之前或之后执行（不论是否失败），
+// 也可以根据执行结果，在成功（OnSuccess）、失败（OnFailure）后执行，或者总是（Always）在最后执行。
+// 其本身允许失败，失败时只是终止同级的剩余hooks执行，不会影响所属单元的执行。
 type Hooks struct {
-	Before []*Action
-	After  []*Action
+	Before    []*Action
+	After     []*Action
+	OnSuccess []*Action
+	OnFailure []*Action
+	Always    []*Action
 }
 
 func doHooks(ctx context.Context, hooks []*Action) (err error) {
@@ -26,3 +34,48 @@ func (h *Hooks) DoBefore(ctx context.Context) error {
 func (h *Hooks) DoAfter(ctx context.Context) error {
 	return doHooks(ctx, h.After)
 }
+
+// runHooks 使用Job的环境变量依次执行hooks，遇到失败即停止
+func runHooks(ctx context.Context, hooks []*Action, envs []string) error {
+	for _, hook := range hooks {
+		if err := hook.exec(ctx, envs, newTailBuffer(maxActionOutput)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runList 执行名为name的一组hooks，失败时只记录日志
+func runList(ctx context.Context, logger *slog.Logger, name string, hooks []*Action, envs []string) {
+	if len(hooks) == 0 {
+		return
+	}
+	if err := runHooks(ctx, hooks, envs); err != nil {
+		logger.Error(fmt.Sprintf("hooks %s failed: %v", name, err), "error", err)
+	}
+}
+
+func (h *Hooks) runBefore(ctx context.Context, logger *slog.Logger, envs []string) {
+	runList(ctx, logger, "before", h.Before, envs)
+}
+
+func (h *Hooks) runAfter(ctx context.Context, logger *slog.Logger, envs []string) {
+	runList(ctx, logger, "after", h.After, envs)
+}
+
+// runOutcome 根据status执行 on_success 或 on_failure hooks，再执行 always hooks。
+// Canceled 不是所属单元自身的失败（而是被中断，或者因为其他Job失败被fail fast取消），因此只执行 always hooks
+func (h *Hooks) runOutcome(ctx context.Context, logger *slog.Logger, status Status, envs []string) {
+	switch status {
+	case Success, AllowedFailure:
+		runList(ctx, logger, "on_success", h.OnSuccess, envs)
+	case Failed, TimedOut:
+		runList(ctx, logger, "on_failure", h.OnFailure, envs)
+	}
+	runList(ctx, logger, "always", h.Always, envs)
+}
+
+// hasOutcome 判断是否存在需要根据执行结果运行的hooks
+func (h *Hooks) hasOutcome() bool {
+	return len(h.OnSuccess) > 0 || len(h.OnFailure) > 0 || len(h.Always) > 0
+}
@@ -5,8 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Meha555/go-pipeline/internal"
@@ -19,6 +24,9 @@ const (
 	Success
 	Failed
 	Skiped
+	Canceled
+	TimedOut
+	AllowedFailure // 允许失败的Job失败了，视为成功但需要提醒
 )
 
 func (s Status) String() string {
@@ -29,25 +37,94 @@ func (s Status) String() string {
 		return "Failed"
 	case Skiped:
 		return "Skiped"
+	case Canceled:
+		return "Canceled"
+	case TimedOut:
+		return "TimedOut"
+	case AllowedFailure:
+		return "AllowedFailure"
 	default:
 		return "Unknown"
 	}
 }
 
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Status) UnmarshalText(text []byte) error {
+	*s = ParseStatus(string(text))
+	return nil
+}
+
+// ParseStatus 将 Status.String 的结果转换回 Status，无法识别时返回 Unknown
+func ParseStatus(name string) Status {
+	for _, status := range []Status{Success, Failed, Skiped, Canceled, TimedOut, AllowedFailure} {
+		if status.String() == name {
+			return status
+		}
+	}
+	return Unknown
+}
+
+// severity 返回状态的严重程度，用于合并多个Job或Stage的状态：
+// 真正的失败比超时更值得关注，超时比取消更值得关注，取消又比允许的失败更值得关注
+func (s Status) severity() int {
+	switch s {
+	case Failed:
+		return 4
+	case TimedOut:
+		return 3
+	case Canceled:
+		return 2
+	case AllowedFailure:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// succeeded 判断状态是否算作成功。允许的失败也算作成功，不影响之后的Stage和依赖它的Job
+func (s Status) succeeded() bool {
+	return s == Success || s == AllowedFailure
+}
+
+// failed 判断状态是否是失败、超时或被取消
+func (s Status) failed() bool {
+	return s == Failed || s == TimedOut || s == Canceled
+}
+
+// worseStatus 返回两个状态中更严重的一个
+func worseStatus(a, b Status) Status {
+	if b.severity() > a.severity() {
+		return b
+	}
+	return a
+}
+
 // Job 组织一个可以并发执行的任务
-// 因此Job的执行可以认为是没有顺序的概念的，如果需要顺序执行两个Job，则应该让这两个Job分别位于两个Stage中
+// 因此Job的执行可以认为是没有顺序的概念的，如果需要顺序执行两个Job，则应该让这两个Job分别位于两个Stage中，
+// 或者通过 Needs 声明依赖，让Job在依赖完成后立即执行，而不必等待之前的Stage全部结束
+// Job只是定义，执行过程中的状态保存在 jobRun 中
 type Job struct {
-	Name         string
-	Actions      []*Action
-	Envs         EnvList
-	Rules        []Rule
-	Exports      EnvList
-	Hooks        *Hooks
-	Timeout      time.Duration
-	AllowFailure bool
-	resCh        chan Status
-	timer        *internal.Timer
-	logger       *slog.Logger
+	Name          string
+	Actions       []*Action
+	Envs          EnvList
+	Rules         []Rule
+	Exports       EnvList
+	Hooks         *Hooks
+	Timeout       time.Duration
+	AllowFailure  bool // 允许Action失败，但Job自身超时仍然是 TimedOut
+	Retry         *RetryPolicy
+	Weight        int    // 占用 --jobs 指定的全局并发容量的权重
+	ResourceGroup string // 占用同一资源组的Job互斥执行
+	Tags          []string
+	// Needs 为nil时表示按Stage顺序调度；非nil（包括空切片）时由Pipeline在依赖的Job全部完成后调度
+	Needs  []string
+	Matrix EnvList // 矩阵Job的变体变量，会作为内置变量注入到该变体的Actions/Hooks中
+	logger *slog.Logger
+	// restored 由 Pipeline.Rerun 设置，不为nil时Job不再执行，而是沿用上一次执行的结果
+	restored *JobResult
 
 	s *Stage
 }
@@ -60,6 +137,12 @@ func WithTimeout(timeout time.Duration) JobOptions {
 	}
 }
 
+func WithTags(tags []string) JobOptions {
+	return func(j *Job) {
+		j.Tags = tags
+	}
+}
+
 func WithAllowFailure(allowFailure bool) JobOptions {
 	return func(j *Job) {
 		j.AllowFailure = allowFailure
@@ -90,15 +173,44 @@ func WithHooks(hooks *Hooks) JobOptions {
 	}
 }
 
+func WithRetry(retry *RetryPolicy) JobOptions {
+	return func(j *Job) {
+		j.Retry = retry
+	}
+}
+
+func WithMatrix(vars EnvList) JobOptions {
+	return func(j *Job) {
+		j.Matrix = vars
+	}
+}
+
+func WithWeight(weight int) JobOptions {
+	return func(j *Job) {
+		j.Weight = weight
+	}
+}
+
+func WithResourceGroup(group string) JobOptions {
+	return func(j *Job) {
+		j.ResourceGroup = group
+	}
+}
+
+func WithNeeds(needs []string) JobOptions {
+	return func(j *Job) {
+		j.Needs = needs
+	}
+}
+
 func NewJob(name string, actions []*Action, s *Stage, opts ...JobOptions) *Job {
 	j := &Job{
 		Name:         name,
 		Actions:      actions,
 		Hooks:        &Hooks{},
-		resCh:        make(chan Status),
 		Timeout:      time.Duration(math.MaxInt64),
 		AllowFailure: false,
-		timer:        &internal.Timer{},
+		Weight:       1,
 		logger:       s.logger.With("job", name),
 		s:            s,
 	}
@@ -110,10 +222,52 @@ func NewJob(name string, actions []*Action, s *Stage, opts ...JobOptions) *Job {
 	return j
 }
 
-func (j *Job) Do(ctx context.Context) (status Status) {
+// jobRun Job在流水线的一次执行中的状态
+type jobRun struct {
+	*Job
+	s        *stageRun
+	needs    []*jobRun
+	exported EnvList // Job成功后解析出的exports，沿着needs依赖传递给下游Job
+	claimed  atomic.Bool
+	// stageSkipped 所属Stage因为不满足执行条件而没有执行，依赖该Job的Job也不会执行
+	stageSkipped bool
+	// timedOut 执行过程中被Pipeline或Stage的超时打断时记录超时的原因
+	timedOut *timeoutError
+	status   Status
+	result   *JobResult
+	done     chan struct{}
+	resCh    chan Status
+	timer    *internal.Timer
+	logger   *slog.Logger
+}
+
+func newJobRun(j *Job, s *stageRun) *jobRun {
+	return &jobRun{
+		Job:    j,
+		s:      s,
+		status: Unknown,
+		result: newJobResult(j),
+		done:   make(chan struct{}),
+		resCh:  make(chan Status, 1), // 由Pipeline提前调度的Job可能在所属Stage开始收集结果之前就结束了，因此需要缓冲
+		timer:  &internal.Timer{},
+		logger: j.logger.With("run_id", s.r.ID),
+	}
+}
+
+// do 执行Job并通过resCh报告其状态。
+// 在等待并发容量时就被fail fast、Stage超时或者取消中断的Job与被跳过的Job一样还没有开始执行，
+// 不会执行任何hooks（包括 after 和 always），只报告 Canceled 或 TimedOut
+func (j *jobRun) do(ctx context.Context) (status Status) {
 	status = Success
-	// 如果不同步一下，单纯的 <- j.resCh 不能代表Job.Do的执行逻辑走完了，特别是还存在defer的情况下
+	// 如果不同步一下，单纯的 <- j.resCh 不能代表Job的执行逻辑走完了，特别是还存在defer的情况下
 	defer j.s.wg.Done()
+	defer j.finish(&status)
+	if j.restored != nil {
+		status = j.restore()
+		j.resCh <- status
+		return
+	}
+	j.result.StartTime = time.Now()
 
 	if trace, ok := ctx.Value(internal.TraceKey).(bool); ok && trace {
 		j.timer.Start()
@@ -128,7 +282,13 @@ func (j *Job) Do(ctx context.Context) (status Status) {
 		case Failed:
 			j.logger.Error(fmt.Sprintf("Job@%s failed", j.Name))
 		case Skiped:
-			j.logger.Info(fmt.Sprintf("Job@%s skipped by rules", j.Name))
+			j.logger.Info(fmt.Sprintf("Job@%s skipped", j.Name))
+		case Canceled:
+			j.logger.Warn(fmt.Sprintf("Job@%s canceled", j.Name))
+		case TimedOut:
+			j.logger.Error(fmt.Sprintf("Job@%s timed out", j.Name))
+		case AllowedFailure:
+			j.logger.Warn(fmt.Sprintf("Job@%s failed, but failure is allowed", j.Name))
 		case Success:
 			j.logger.Info(fmt.Sprintf("Job@%s success", j.Name))
 		default:
@@ -136,61 +296,348 @@ func (j *Job) Do(ctx context.Context) (status Status) {
 		}
 	}()
 
+	if need := j.failedNeed(); need != nil {
+		j.logger.Warn(fmt.Sprintf("Job@%s skipped because needed job %s failed or did not run", j.Name, need.Name), "need", need.Name)
+		status = Skiped
+		j.resCh <- status
+		return
+	}
+
+	// 被提前调度的Job可能在Stage开始之前就开始执行，Stage的超时从第一个开始执行的Job算起
+	j.s.startDeadline()
+	// 开启fail fast或者Stage超时时，Stage会取消当前Job
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(j.s.abort, func() { cancel(context.Cause(j.s.abort)) })
+	defer stop()
+
+	// 等待Stage和全局的并发容量，排队的时间不计入Job的执行时间
+	release, err := j.acquire(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			// 不是被取消，而是文件锁出错
+			j.logger.Error(fmt.Sprintf("Job@%s failed to start: %v", j.Name, err), "error", err)
+			status = Failed
+			j.resCh <- status
+			return
+		}
+		// 还没有开始执行，before hooks 也没有执行过，因此不执行任何hooks
+		j.logger.Warn(fmt.Sprintf("Job@%s interrupted while waiting to start: %v", j.Name, context.Cause(ctx)), "cause", context.Cause(ctx))
+		status = interruptedStatus(ctx)
+		j.resCh <- status
+		return
+	}
+	defer release()
+	j.result.StartTime = time.Now()
+
 	if j.Timeout != time.Duration(math.MaxInt64) {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
 		defer cancel()
 	}
 
-	// 向Job中的Actions/Hooks注入环境变量。不能直接给当前进程注入，因为Job是并发执行的，在Job.Do中修改。
-	jobEnv := j.buildEnv()
-	applyActionEnvs(j.Hooks.Before, jobEnv)
-	applyActionEnvs(j.Actions, jobEnv)
-	applyActionEnvs(j.Hooks.After, jobEnv)
+	// 向Job中的Actions/Hooks注入环境变量。不能直接给当前进程注入，因为Job是并发执行的。
+	env := j.s.r.env
+	jobEnv := j.buildEnv(env)
 
 	// 检查Job的rules
-	if len(j.Rules) > 0 && !j.matchRules(ctx, jobEnv) {
+	if len(j.Rules) > 0 && !j.matchRules(ctx, env, jobEnv) {
+		j.logger.Info(fmt.Sprintf("Job@%s no rules matched", j.Name))
 		status = Skiped
 		j.resCh <- status
 		return
 	}
 
-	if len(j.Hooks.Before) > 0 {
-		if err := j.Hooks.DoBefore(ctx); err != nil {
-			j.logger.Error(fmt.Sprintf("hooks before failed: %v", err), "error", err)
+	// Actions和Hooks的输出保存到Job的日志文件中，无法创建日志文件时不影响Job的执行
+	if logDir := j.s.r.p.LogDir; logDir != "" {
+		log, err := openJobLog(JobLogPath(logDir, j.s.r.ID, j.Name), j.s.r.p.LogMaxSize)
+		if err != nil {
+			j.logger.Warn(err.Error(), "error", err)
+		} else {
+			j.result.LogFile = log.path
+			ctx = withJobLog(ctx, log)
+			defer log.Close()
+		}
+	}
+	if onFailure, ok := ctx.Value(internal.OutputOnFailureKey).(bool); ok && onFailure {
+		defer func() {
+			// 被取消的Job不是因为自身的问题失败的，不需要输出
+			switch status {
+			case Failed, TimedOut, AllowedFailure:
+				j.printFailureOutput(status)
+			}
+		}()
+	}
+
+	// Actions/Hooks可以向 PIPELINE_OUTPUT 指向的文件写入 KEY=VALUE，Job成功后作为exports传递给之后的Job
+	outputPath, err := newJobOutputFile()
+	if err != nil {
+		j.logger.Error(err.Error(), "error", err)
+		status = Failed
+		j.resCh <- status
+		return
+	}
+	defer os.Remove(outputPath)
+	jobEnv = append(jobEnv, envLine("PIPELINE_OUTPUT", outputPath))
+
+	j.Hooks.runBefore(ctx, j.logger, jobEnv)
+	var failedAction *Action // 导致Job失败、超时或被取消的Action，允许失败时为第一个失败的Action
+	attempt := 1
+	for ; ; attempt++ {
+		attemptEnv := append(slices.Clip(jobEnv), envLine("JOB_ATTEMPT", strconv.Itoa(attempt)))
+		var err error
+		if status, failedAction, err = j.runActions(ctx, attemptEnv); !j.retryJob(ctx, attempt, status, err) {
+			break
+		}
+		// 重新执行整个Job时，上一次执行的结果以及写入输出文件的变量都作废
+		j.result.Actions = j.result.Actions[:0]
+		if err := os.WriteFile(outputPath, nil, 0o600); err != nil {
+			j.logger.Warn(fmt.Sprintf("reset job output file failed: %v", err), "error", err)
 		}
 	}
+	if j.Retry != nil && j.Retry.WholeJob {
+		j.result.Attempts = attempt
+	}
+	jobEnv = append(jobEnv, envLine("JOB_ATTEMPT", strconv.Itoa(attempt)))
+	// after hooks 不论Job是否超时或被取消都要执行，因此不能继承已经结束的ctx
+	j.Hooks.runAfter(context.WithoutCancel(ctx), j.logger, jobEnv)
+	if status.succeeded() {
+		if err := j.collectExports(env, outputPath); err != nil {
+			j.logger.Error(err.Error(), "error", err)
+			status = Failed
+		}
+	}
+	// 根据Job的最终状态执行对应的hooks，同样不能继承已经结束的ctx
+	j.runOutcomeHooks(context.WithoutCancel(ctx), status, failedAction, jobEnv)
+	j.resCh <- status
+	return
+}
+
+// runActions 依次执行Job的全部Actions，返回Job的状态、导致Job失败的Action以及第一个失败的Action的错误
+func (j *jobRun) runActions(ctx context.Context, jobEnv []string) (status Status, failedAction *Action, actionErr error) {
+	status = Success
 	for _, action := range j.Actions {
 		// 要求Exec是阻塞的
-		if err := action.Exec(ctx); err != nil {
+		if err := j.execAction(ctx, action, jobEnv); err != nil {
+			if actionErr == nil {
+				actionErr = err
+			}
+			// Pipeline或Stage超时，即使允许失败也不再继续执行后续Action
+			if timeout := timeoutCause(ctx); timeout != nil {
+				j.logger.Error(fmt.Sprintf("action (%s) interrupted: %v", action, timeout), "action", action.String(), "cause", timeout)
+				status = TimedOut
+				j.timedOut = timeout
+				failedAction = action
+				break
+			}
+			// 整个流水线被取消（而不是Job自身超时），即使允许失败也不再继续执行后续Action
+			if errors.Is(ctx.Err(), context.Canceled) {
+				j.logger.Warn(fmt.Sprintf("action (%s) canceled", action), "action", action.String())
+				status = Canceled
+				failedAction = action
+				break
+			}
+			// Job自身超时后，之后的Action也无法执行。allow_failure 只允许命令失败，超时仍然是 TimedOut
 			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 				j.logger.Error(fmt.Sprintf("action (%s) timeout %v exceeded", action, j.Timeout), "action", action.String(), "timeout", j.Timeout)
-			} else {
-				j.logger.Error(fmt.Sprintf("action (%s) failed: %v", action, err), "error", err, "action", action.String())
+				status = TimedOut
+				failedAction = action
+				break
 			}
+			j.logger.Error(fmt.Sprintf("action (%s) failed: %v", action, err), "error", err, "action", action.String())
 			if !j.AllowFailure {
 				status = Failed
+				failedAction = action
 				break
 			}
+			status = AllowedFailure
+			if failedAction == nil {
+				failedAction = action
+			}
 		}
 	}
-	if len(j.Hooks.After) > 0 {
-		if err := j.Hooks.DoAfter(ctx); err != nil {
-			j.logger.Error(fmt.Sprintf("hooks after failed: %v", err), "error", err)
+	return
+}
+
+// retryJob 判断第attempt次执行失败后是否应该从第一个Action开始重新执行整个Job，需要重试时等待退避时间。
+// 超时或者被取消时重试没有意义
+func (j *jobRun) retryJob(ctx context.Context, attempt int, status Status, err error) bool {
+	if j.Retry == nil || !j.Retry.WholeJob || ctx.Err() != nil {
+		return false
+	}
+	if status != Failed && status != AllowedFailure || !j.Retry.shouldRetry(attempt, err) {
+		return false
+	}
+	delay := j.Retry.delay(attempt)
+	j.logger.Warn(fmt.Sprintf("Job@%s failed on attempt %d/%d: %v, retry in %v", j.Name, attempt, j.Retry.Max+1, err, delay), "error", err, "attempt", attempt, "delay", delay)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// runOutcomeHooks 根据Job的最终状态执行 on_success 或 on_failure hooks，再执行 always hooks。
+// 这些hooks可以通过 JOB_STATUS 和 JOB_FAILED_ACTION 得知Job的执行结果
+func (j *jobRun) runOutcomeHooks(ctx context.Context, status Status, failedAction *Action, jobEnv []string) {
+	if !j.Hooks.hasOutcome() {
+		return
+	}
+	failed := ""
+	if failedAction != nil {
+		failed = strings.TrimSpace(failedAction.String())
+	}
+	envs := append(slices.Clip(jobEnv), envLine("JOB_STATUS", status.String()), envLine("JOB_FAILED_ACTION", failed))
+	j.Hooks.runOutcome(ctx, j.logger, status, envs)
+}
+
+// collectExports 汇总Job导出的变量：先是Actions/Hooks写入输出文件的变量，再是exports中声明的变量，同名时以exports为准。
+// exports的值可以引用输出文件中的变量
+func (j *jobRun) collectExports(env *runEnv, outputPath string) error {
+	outputs, err := readJobOutputFile(outputPath)
+	if err != nil {
+		return err
+	}
+	exported := EnvList{}
+	for _, item := range outputs {
+		setOutput(&exported, item.Key, item.Value)
+	}
+	for _, item := range resolveEnvList(env, j.s.r.p.Shell, j.Exports, outputs, j.needsExports()) {
+		setOutput(&exported, item.Key, item.Value)
+	}
+	if len(exported) > 0 {
+		j.exported = exported
+		j.result.Exports = exported.ToMap()
+	}
+	return nil
+}
+
+// finish 记录Job的最终状态及执行结果，并通知依赖它的Job
+func (j *jobRun) finish(status *Status) {
+	j.status = *status
+	// Job自身超时同样触发fail fast；被流水线或Stage超时中断的Job已经随Stage一起被取消了
+	if *status == Failed || *status == TimedOut && j.timedOut == nil {
+		j.s.jobFailed(j)
+	}
+	// 沿用上一次执行的结果时保持原样
+	if j.result.RestoredFrom == "" {
+		j.result.Status = *status
+		j.result.EndTime = time.Now()
+		j.result.Duration = j.result.EndTime.Sub(j.result.StartTime)
+		// 没有执行到的Action也记录下来
+		for _, action := range j.Actions[len(j.result.Actions):] {
+			j.result.Actions = append(j.result.Actions, &ActionResult{Command: action.String(), Status: Skiped})
 		}
 	}
-	j.resCh <- status
-	return
+	close(j.done)
 }
 
-func (j *Job) buildEnv() []string {
+// restore 沿用上一次执行的结果，并恢复其导出的变量，使得之后的Job得到与上一次相同的变量
+func (j *jobRun) restore() Status {
+	result := *j.restored
+	j.result = &result
+	for _, key := range slices.Sorted(maps.Keys(result.Exports)) {
+		j.exported.Append(key, result.Exports[key])
+	}
+	j.logger.Info(fmt.Sprintf("Job@%s restored from run %s", j.Name, result.RestoredFrom), "restored_from", result.RestoredFrom)
+	return result.Status
+}
+
+// needsExports 收集所依赖的Job导出的变量，同名变量以后声明的依赖为准
+func (j *jobRun) needsExports() EnvList {
+	exports := EnvList{}
+	index := make(map[string]int)
+	for _, need := range j.needs {
+		for _, env := range need.exported {
+			if i, exists := index[env.Key]; exists {
+				exports[i].Value = env.Value
+				continue
+			}
+			index[env.Key] = len(exports)
+			exports.Append(env.Key, env.Value)
+		}
+	}
+	return exports
+}
+
+// acquire 依次占用资源组、所属Stage和全局的并发容量，返回归还它们的函数。
+// 所有Job都按照同样的顺序占用，避免互相等待对方持有的资源
+func (j *jobRun) acquire(ctx context.Context) (release func(), err error) {
+	unlock := func() {}
+	if j.ResourceGroup != "" {
+		j.logger.Debug(fmt.Sprintf("Job@%s waiting for resource group %s", j.Name, j.ResourceGroup), "resource_group", j.ResourceGroup)
+		if unlock, err = lockResourceGroup(ctx, j.ResourceGroup, j.s.r.lockDir); err != nil {
+			return nil, err
+		}
+	}
+	stageUsed, err := j.s.capacity.acquire(ctx, 1)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	globalUsed, err := j.s.r.capacity.acquire(ctx, j.Weight)
+	if err != nil {
+		j.s.capacity.release(stageUsed)
+		unlock()
+		return nil, err
+	}
+	return func() {
+		j.s.r.capacity.release(globalUsed)
+		j.s.capacity.release(stageUsed)
+		unlock()
+	}, nil
+}
+
+// claim 占用Job，保证Job只会被执行或者被跳过一次
+func (j *jobRun) claim() bool {
+	return j.claimed.CompareAndSwap(false, true)
+}
+
+// doAfterNeeds 等待所依赖的Job全部完成后执行Job，abort被关闭时放弃执行
+func (j *jobRun) doAfterNeeds(ctx context.Context, abort <-chan struct{}) {
+	for _, need := range j.needs {
+		select {
+		case <-need.done:
+		case <-abort:
+			return
+		}
+	}
+	if !j.claim() {
+		return
+	}
+	j.s.wg.Add(1)
+	j.do(ctx)
+}
+
+// failedNeed 返回导致当前Job无法执行的上游Job：上游失败或被取消，上游所属的Stage没有执行，或上游本身因为依赖失败而被跳过
+func (j *jobRun) failedNeed() *jobRun {
+	for _, need := range j.needs {
+		switch need.status {
+		case Failed, Canceled, TimedOut:
+			return need
+		case Skiped:
+			if need.stageSkipped || need.failedNeed() != nil {
+				return need
+			}
+		}
+	}
+	return nil
+}
+
+func (j *jobRun) buildEnv(env *runEnv) []string {
 	// 初始化job的环境变量（往pipeline的环境变量列表中覆盖）
-	builtin := EnvList{{Key: "JOB_NAME", Value: j.Name}}
-	resolved := resolveEnvList(j.s.p.Shell, j.Envs, builtin, j.s.p.Envs)
-	result := make([]string, 0, len(builtin)+len(resolved))
+	builtin := EnvList{{Key: "STAGE_NAME", Value: j.s.Name}, {Key: "JOB_NAME", Value: j.Name}, {Key: "JOB_TAGS", Value: strings.Join(j.Tags, ",")}}
+	builtin.Merge(j.Matrix)
+	exports := j.needsExports()
+	resolved := resolveEnvList(env, j.s.r.p.Shell, j.Envs, builtin, exports)
+	result := make([]string, 0, len(builtin)+len(exports)+len(resolved))
 	for _, env := range builtin {
 		result = append(result, envLine(env.Key, env.Value))
 	}
+	for _, env := range exports {
+		result = append(result, envLine(env.Key, env.Value))
+	}
 	for _, env := range resolved {
 		result = append(result, envLine(env.Key, env.Value))
 	}
@@ -201,27 +648,14 @@ func envLine(key, value string) string {
 	return fmt.Sprintf("%s=%s", key, value)
 }
 
-func applyActionEnvs(actions []*Action, envs []string) {
-	for _, action := range actions {
-		action.SetEnvs(envs)
-	}
-}
-
-func (j *Job) Result() <-chan Status {
-	return j.resCh
-}
-
-func (j *Job) importExports() error {
-	resolved := resolveEnvList(j.s.p.Shell, j.Exports, j.s.p.Envs)
+// importExports 将Job导出的变量注入到本次执行的运行环境中，供之后的Stage使用
+func (j *jobRun) importExports(env *runEnv) {
 	seen := make(map[string]struct{})
-	for _, env := range resolved {
-		if _, exists := seen[env.Key]; exists {
-			slog.Warn(fmt.Sprintf("export variable %s is overwritten", env.Key), "key", env.Key)
-		}
-		seen[env.Key] = struct{}{}
-		if err := os.Setenv(env.Key, env.Value); err != nil {
-			return fmt.Errorf("set export %s failed: %w", env.Key, err)
+	for _, item := range j.exported {
+		if _, exists := seen[item.Key]; exists {
+			slog.Warn(fmt.Sprintf("export variable %s is overwritten", item.Key), "key", item.Key)
 		}
+		seen[item.Key] = struct{}{}
+		env.set(item.Key, item.Value)
 	}
-	return nil
 }
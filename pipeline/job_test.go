@@ -2,9 +2,12 @@ package pipeline
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestJobEnvsOverridePipelineEnvsOnlyForThatJob(t *testing.T) {
@@ -12,7 +15,7 @@ func TestJobEnvsOverridePipelineEnvsOnlyForThatJob(t *testing.T) {
 	restoreWdAfterTest(t)
 	t.Setenv("JOB_VAR", "")
 	t.Setenv("JOB_NAME", "")
-	p := NewPipeline("test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithEnvs(EnvList{
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithEnvs(EnvList{
 		{Key: "GLOBAL_VAR", Value: "global"},
 		{Key: "SHARED", Value: "from-global"},
 	}))
@@ -46,7 +49,7 @@ func TestJobEnvsOverridePipelineEnvsOnlyForThatJob(t *testing.T) {
 func TestJobEnvsCanReferenceJobNameAndPreviousJobEnv(t *testing.T) {
 	tmpDir := t.TempDir()
 	restoreWdAfterTest(t)
-	p := NewPipeline("test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
 	build := NewStage("build", p)
 	build.AddJob(NewJob("build_job", []*Action{
 		NewAction(p.Shell, "printf '%s' \"$PACKAGE\" > package.out"),
@@ -67,3 +70,123 @@ func TestJobEnvsCanReferenceJobNameAndPreviousJobEnv(t *testing.T) {
 		t.Fatalf("package output = %q, want build_job/pkg", got)
 	}
 }
+
+func TestJobOutcomeHooksSeeJobStatus(t *testing.T) {
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	record := func(name string) []*Action {
+		return []*Action{NewAction(p.Shell, fmt.Sprintf(`printf '%%s|%%s' "$JOB_STATUS" "$JOB_FAILED_ACTION" > "$JOB_NAME.%s"`, name))}
+	}
+	hooks := func() *Hooks {
+		return &Hooks{OnSuccess: record("on_success"), OnFailure: record("on_failure"), Always: record("always")}
+	}
+	build := NewStage("build", p)
+	build.AddJob(NewJob("ok_job", []*Action{NewAction(p.Shell, "true")}, build, WithHooks(hooks())))
+	build.AddJob(NewJob("bad_job", []*Action{NewAction(p.Shell, "true"), NewAction(p.Shell, "exit 3"), NewAction(p.Shell, "true")}, build, WithHooks(hooks())))
+	build.AddJob(NewJob("allowed_job", []*Action{NewAction(p.Shell, "exit 3")}, build, WithAllowFailure(true), WithHooks(hooks())))
+	p.AddStage(build)
+
+	if status := p.Run(context.Background()); status != Failed {
+		t.Fatalf("Pipeline status = %s, want Failed", status)
+	}
+	want := map[string]string{
+		"ok_job.on_success":      "Success|",
+		"ok_job.always":          "Success|",
+		"bad_job.on_failure":     "Failed|exit 3",
+		"bad_job.always":         "Failed|exit 3",
+		"allowed_job.on_success": "AllowedFailure|exit 3",
+		"allowed_job.always":     "AllowedFailure|exit 3",
+	}
+	for name, value := range want {
+		got, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Errorf("read %s: %v", name, err)
+			continue
+		}
+		if string(got) != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	for _, name := range []string{"ok_job.on_failure", "bad_job.on_success", "allowed_job.on_failure"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err == nil {
+			t.Errorf("%s hook ran, want not run", name)
+		}
+	}
+}
+
+func TestCanceledJobAndStageRunOnlyAlwaysHooks(t *testing.T) {
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	hooks := func(unit string) *Hooks {
+		record := func(name string) []*Action {
+			return []*Action{NewAction(p.Shell, "touch "+unit+"."+name)}
+		}
+		return &Hooks{OnSuccess: record("on_success"), OnFailure: record("on_failure"), Always: record("always")}
+	}
+	build := NewStage("build", p, WithStageHooks(hooks("stage")))
+	build.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "sleep 5")}, build, WithHooks(hooks("job"))))
+	p.AddStage(build)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	if status := p.Run(ctx); status != Canceled {
+		t.Fatalf("Pipeline status = %s, want Canceled", status)
+	}
+	for _, name := range []string{"job.always", "stage.always"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("%s hook did not run after cancel: %v", name, err)
+		}
+	}
+	for _, name := range []string{"job.on_success", "job.on_failure", "stage.on_success", "stage.on_failure"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err == nil {
+			t.Errorf("%s hook ran after cancel, want not run", name)
+		}
+	}
+}
+
+func TestAllowedFailuresAndJobTimeoutsPropagateToSummaries(t *testing.T) {
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	lint := NewStage("lint", p)
+	lint.AddJob(NewJob("lint_job", []*Action{NewAction(p.Shell, "exit 1"), NewAction(p.Shell, "touch lint.done")}, lint, WithAllowFailure(true)))
+	p.AddStage(lint)
+	test := NewStage("test", p)
+	test.AddJob(NewJob("test_job", []*Action{NewAction(p.Shell, "sleep 10")}, test, WithTimeout(200*time.Millisecond)))
+	// allow_failure 只允许命令失败，Job自身超时仍然是 TimedOut
+	test.AddJob(NewJob("flaky_job", []*Action{NewAction(p.Shell, "sleep 10"), NewAction(p.Shell, "touch flaky.done")}, test, WithAllowFailure(true), WithTimeout(200*time.Millisecond)))
+	p.AddStage(test)
+
+	result := p.Execute(context.Background())
+	if result.Status != TimedOut {
+		t.Fatalf("pipeline status = %s, want TimedOut", result.Status)
+	}
+	if status := result.Stages[0].Status; status != AllowedFailure {
+		t.Fatalf("lint stage status = %s, want AllowedFailure", status)
+	}
+	if got := result.JobsWithStatus(AllowedFailure); len(got) != 1 || got[0] != "lint_job" {
+		t.Fatalf("allowed failures = %v, want [lint_job]", got)
+	}
+	if got := result.JobsWithStatus(TimedOut); !reflect.DeepEqual(got, []string{"test_job", "flaky_job"}) {
+		t.Fatalf("timed out jobs = %v, want [test_job flaky_job]", got)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "flaky.done")); !os.IsNotExist(err) {
+		t.Fatalf("flaky_job continued after its timeout, stat error = %v", err)
+	}
+	if status := result.Stages[1].Jobs[0].Actions[0].Status; status != TimedOut {
+		t.Fatalf("test_job action status = %s, want TimedOut", status)
+	}
+	// 允许失败的Job会继续执行之后的Action
+	if _, err := os.Stat(filepath.Join(tmpDir, "lint.done")); err != nil {
+		t.Fatalf("lint_job did not continue after an allowed failure: %v", err)
+	}
+}
+
+func TestStatusRoundTripsThroughText(t *testing.T) {
+	for _, status := range []Status{Success, Failed, Skiped, Canceled, TimedOut, AllowedFailure} {
+		text, _ := status.MarshalText()
+		var got Status
+		if err := got.UnmarshalText(text); err != nil || got != status {
+			t.Errorf("UnmarshalText(%q) = %s, %v, want %s", text, got, err, status)
+		}
+	}
+}
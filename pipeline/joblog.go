@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultLogMaxSize 每个Job的日志文件默认的大小上限
+const DefaultLogMaxSize = 10 << 20
+
+// JobLogPath 返回执行runID中Job的日志文件路径：<logDir>/<runID>/<job>.log
+func JobLogPath(logDir, runID, job string) string {
+	// Job名中不能出现在文件名中的字符替换为下划线
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, job)
+	return filepath.Join(logDir, runID, name+".log")
+}
+
+// jobLog Job的日志文件，Job中Actions和Hooks的stdout和stderr都会写入其中。
+// 超过大小上限后的输出被丢弃；写入失败也只是丢弃输出，不会导致Action失败
+type jobLog struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	limit     int64 // 不大于0时不限制
+	written   int64
+	truncated bool
+}
+
+func openJobLog(path string, limit int64) (*jobLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create job log: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create job log: %w", err)
+	}
+	return &jobLog{path: path, file: file, limit: limit}, nil
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.truncated {
+		return len(p), nil
+	}
+	data := p
+	if l.limit > 0 && l.written+int64(len(data)) > l.limit {
+		data = data[:l.limit-l.written]
+		l.truncated = true
+	}
+	n, _ := l.file.Write(data)
+	l.written += int64(n)
+	if l.truncated {
+		fmt.Fprintf(l.file, "\n...(log truncated at %d bytes)\n", l.limit)
+	}
+	return len(p), nil
+}
+
+func (l *jobLog) Close() error {
+	return l.file.Close()
+}
+
+type jobLogKey struct{}
+
+func withJobLog(ctx context.Context, log *jobLog) context.Context {
+	return context.WithValue(ctx, jobLogKey{}, log)
+}
+
+// jobLogFrom 返回ctx中携带的Job日志文件，不在Job中执行或者没有开启日志文件时返回nil
+func jobLogFrom(ctx context.Context) *jobLog {
+	log, _ := ctx.Value(jobLogKey{}).(*jobLog)
+	return log
+}
+
+// printMu 避免并发执行的Job输出的日志相互交错
+var printMu sync.Mutex
+
+// printFailureOutput 输出失败的Job的日志，没有日志文件时输出每个Action最后一次执行的输出尾部
+func (j *jobRun) printFailureOutput(status Status) {
+	var b strings.Builder
+	if path := j.result.LogFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			j.logger.Error(fmt.Sprintf("read job log failed: %v", err), "error", err, "log_file", path)
+			return
+		}
+		b.Write(data)
+	} else {
+		for _, action := range j.result.Actions {
+			if action.Status == Skiped {
+				continue
+			}
+			fmt.Fprintf(&b, "$ %s\n%s", strings.TrimSpace(action.Command), action.Output)
+			if action.Output != "" && !strings.HasSuffix(action.Output, "\n") {
+				b.WriteByte('\n')
+			}
+		}
+	}
+	output := b.String()
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+	printMu.Lock()
+	defer printMu.Unlock()
+	fmt.Fprintf(os.Stdout, "----- Job@%s %s, output: -----\n%s----- end of Job@%s output -----\n", j.Name, status, output, j.Name)
+}
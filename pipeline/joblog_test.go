@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobLogCapturesActionsAndHooks(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	tmpDir := t.TempDir()
+	logDir := filepath.Join(tmpDir, "logs")
+
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithJobLogs(logDir, 0))
+	s := NewStage("build", p)
+	s.AddJob(NewJob("build/job", []*Action{
+		NewAction(p.Shell, "echo to stdout"),
+		NewAction(p.Shell, "echo to stderr >&2; exit 3"),
+	}, s, WithHooks(&Hooks{Always: []*Action{NewAction(p.Shell, "echo from hook")}})))
+	p.AddStage(s)
+
+	result := p.Execute(context.Background())
+	job := result.Stages[0].Jobs[0]
+	if want := JobLogPath(logDir, result.RunID, "build/job"); job.LogFile != want || filepath.Base(want) != "build_job.log" {
+		t.Fatalf("LogFile = %q, want %q", job.LogFile, want)
+	}
+	data, err := os.ReadFile(job.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"$ echo to stdout", "to stdout\n", "to stderr\n", "$ echo from hook", "from hook\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("job log missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestJobLogDropsOutputBeyondLimit(t *testing.T) {
+	log, err := openJobLog(filepath.Join(t.TempDir(), "run", "job.log"), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"12345", "67890", "more"} {
+		if n, err := log.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	log.Close()
+	data, err := os.ReadFile(log.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "12345678\n...(log truncated at 8 bytes)\n"; string(data) != want {
+		t.Fatalf("job log = %q, want %q", data, want)
+	}
+}
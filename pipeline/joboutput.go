@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// newJobOutputFile 为Job的一次执行创建一个空的输出文件，其路径通过 PIPELINE_OUTPUT 传递给Actions/Hooks
+func newJobOutputFile() (string, error) {
+	f, err := os.CreateTemp("", "pipeline-output-*")
+	if err != nil {
+		return "", fmt.Errorf("create job output file failed: %w", err)
+	}
+	defer f.Close()
+	return f.Name(), nil
+}
+
+// readJobOutputFile 读取并解析Job的输出文件
+func readJobOutputFile(path string) (EnvList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read job output file failed: %w", err)
+	}
+	outputs, err := parseJobOutput(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse job output file failed: %w", err)
+	}
+	return outputs, nil
+}
+
+// parseJobOutput 解析Job输出文件的内容，每行为 KEY=VALUE，或者使用 heredoc 语法写入多行的值：
+//
+//	KEY<<DELIMITER
+//	line 1
+//	line 2
+//	DELIMITER
+//
+// 变量名必须符合 [A-Za-z_][A-Za-z0-9_]*，空行会被忽略，同名变量以后写入的为准
+func parseJobOutput(content string) (EnvList, error) {
+	outputs := EnvList{}
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lineNo := i + 1
+		eq := strings.Index(line, "=")
+		heredoc := strings.Index(line, "<<")
+		switch {
+		case heredoc > 0 && (eq < 0 || heredoc < eq):
+			key, delimiter := line[:heredoc], line[heredoc+2:]
+			if !isValidEnvKey(key) {
+				return nil, fmt.Errorf("line %d: invalid variable name %q", lineNo, key)
+			}
+			if delimiter == "" {
+				return nil, fmt.Errorf("line %d: missing delimiter for %s", lineNo, key)
+			}
+			end := -1
+			for j := i + 1; j < len(lines); j++ {
+				if lines[j] == delimiter {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: delimiter %q for %s not found", lineNo, delimiter, key)
+			}
+			setOutput(&outputs, key, strings.Join(lines[i+1:end], "\n"))
+			i = end
+		case eq > 0:
+			if !isValidEnvKey(line[:eq]) {
+				return nil, fmt.Errorf("line %d: invalid variable name %q", lineNo, line[:eq])
+			}
+			setOutput(&outputs, line[:eq], line[eq+1:])
+		default:
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE or KEY<<DELIMITER, got %q", lineNo, line)
+		}
+	}
+	return outputs, nil
+}
+
+func setOutput(outputs *EnvList, key, value string) {
+	for i := range *outputs {
+		if (*outputs)[i].Key == key {
+			(*outputs)[i].Value = value
+			return
+		}
+	}
+	outputs.Append(key, value)
+}
@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJobOutput(t *testing.T) {
+	content := "A=1\r\n\nB=x=y\nC<<END\nfirst\n\nEND=not the end\nEND\nA=2\nD=\n"
+	got, err := parseJobOutput(content)
+	if err != nil {
+		t.Fatalf("parseJobOutput() error = %v", err)
+	}
+	want := map[string]string{"A": "2", "B": "x=y", "C": "first\n\nEND=not the end", "D": ""}
+	if !reflect.DeepEqual(got.ToMap(), want) {
+		t.Fatalf("parseJobOutput() = %v, want %v", got.ToMap(), want)
+	}
+}
+
+func TestParseJobOutputRejectsMalformedLines(t *testing.T) {
+	tests := map[string]string{
+		"no separator":      "JUST_TEXT",
+		"empty key":         "=value",
+		"missing delimiter": "NOTES<<",
+		"unterminated":      "NOTES<<EOF\nline",
+		"key with space":    "FOO BAR=1",
+		"key with digit":    "1X=2",
+		"invalid heredoc":   "BAD-KEY<<EOF\nline\nEOF",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseJobOutput(content)
+			if err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+				t.Fatalf("parseJobOutput() error = %v, want line 1 error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// resourceGroups 进程内的资源组锁，同一进程中并发执行的多条流水线也共享这些锁
+var resourceGroups = struct {
+	mu    sync.Mutex
+	locks map[string]*capacity
+}{locks: make(map[string]*capacity)}
+
+// fileLockPollInterval 文件锁被其他进程占用时重试的间隔
+const fileLockPollInterval = 100 * time.Millisecond
+
+func resourceGroupLock(name string) *capacity {
+	resourceGroups.mu.Lock()
+	defer resourceGroups.mu.Unlock()
+	lock, exists := resourceGroups.locks[name]
+	if !exists {
+		lock = newCapacity(1)
+		resourceGroups.locks[name] = lock
+	}
+	return lock
+}
+
+// lockResourceGroup 占用名为name的资源组，同一时间只有一个Job可以占用同一个资源组。
+// lockDir不为空时还会占用lockDir中的同名文件锁，使得同一台机器上的其他流水线进程也遵守该锁
+func lockResourceGroup(ctx context.Context, name, lockDir string) (unlock func(), err error) {
+	lock := resourceGroupLock(name)
+	used, err := lock.acquire(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	if lockDir == "" {
+		return func() { lock.release(used) }, nil
+	}
+	unlockFile, err := lockFile(ctx, filepath.Join(lockDir, name+".lock"))
+	if err != nil {
+		lock.release(used)
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		lock.release(used)
+	}, nil
+}
+
+// lockFile 占用path上的文件锁，被其他进程占用时每隔 fileLockPollInterval 重试，直到成功或者ctx结束
+func lockFile(ctx context.Context, path string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create lock dir failed: %w", err)
+	}
+	for {
+		unlock, locked, err := tryLockFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("lock %s failed: %w", path, err)
+		}
+		if locked {
+			return unlock, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(fileLockPollInterval):
+		}
+	}
+}
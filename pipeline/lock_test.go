@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobsInSameResourceGroupDoNotOverlap(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	s := NewStage("test", p)
+	for _, name := range []string{"db1", "db2", "db3"} {
+		s.AddJob(NewJob(name, []*Action{NewAction(p.Shell, "echo + >> db.log; sleep 0.2; echo - >> db.log")}, s, WithResourceGroup("database")))
+	}
+	s.AddJob(NewJob("unit", []*Action{NewAction(p.Shell, "sleep 0.1; cat db.log > unit.log")}, s))
+	p.AddStage(s)
+
+	if status := p.Run(context.Background()); status != Success {
+		t.Fatalf("pipeline status = %s, want Success", status)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "db.log"))
+	if err != nil {
+		t.Fatalf("read db.log: %v", err)
+	}
+	if got := strings.Join(strings.Fields(string(content)), ""); got != "+-+-+-" {
+		t.Fatalf("resource group jobs overlapped: %q", got)
+	}
+	// 不属于资源组的Job不需要等待
+	unit, err := os.ReadFile(filepath.Join(tmpDir, "unit.log"))
+	if err != nil {
+		t.Fatalf("read unit.log: %v", err)
+	}
+	if got := strings.Join(strings.Fields(string(unit)), ""); got != "+" {
+		t.Fatalf("unit job saw db.log = %q, want it to run alongside the first resource group job", got)
+	}
+}
+
+func TestResourceGroupUsesFileLockInLockDir(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "locks"), 0o755); err != nil {
+		t.Fatalf("create lock dir: %v", err)
+	}
+	// 模拟另一个流水线进程持有文件锁
+	unlock, locked, err := tryLockFile(filepath.Join(tmpDir, "locks", "device.lock"))
+	if err != nil || !locked {
+		t.Fatalf("tryLockFile() = %v, %v, want locked", locked, err)
+	}
+
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithLockDir("locks"))
+	s := NewStage("test", p)
+	s.AddJob(NewJob("flash", []*Action{NewAction(p.Shell, "touch flashed")}, s, WithResourceGroup("device")))
+	p.AddStage(s)
+
+	done := make(chan Status)
+	go func() { done <- p.Run(context.Background()) }()
+	time.Sleep(3 * fileLockPollInterval)
+	if _, err := os.Stat(filepath.Join(tmpDir, "flashed")); err == nil {
+		t.Fatal("job ran while the file lock was held by another process")
+	}
+	unlock()
+	if status := <-done; status != Success {
+		t.Fatalf("pipeline status = %s, want Success", status)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "flashed")); err != nil {
+		t.Fatalf("job did not run after the file lock was released: %v", err)
+	}
+}
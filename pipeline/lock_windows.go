@@ -0,0 +1,27 @@
+//go:build windows
+
+package pipeline
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ERROR_SHARING_VIOLATION
+const errSharingViolation syscall.Errno = 32
+
+// tryLockFile 尝试以不共享的方式打开path，文件已经被其他进程打开时返回false。进程退出后文件句柄会被自动关闭
+func tryLockFile(path string) (unlock func(), locked bool, err error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, false, err
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, errSharingViolation) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return func() { syscall.CloseHandle(handle) }, true, nil
+}
@@ -5,19 +5,16 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"os/signal"
-	"strings"
-	"syscall"
+	"sync"
 	"time"
 
-	"github.com/Meha555/go-pipeline/internal"
 	"github.com/Meha555/go-pipeline/parser"
 	"github.com/robfig/cron/v3"
 )
 
 type EnvList = parser.DictList[string, string]
 
-// Pipeline 定义流水线结构体
+// Pipeline 定义流水线结构体。Pipeline只是定义，每次执行的状态保存在 Run 中，因此同一个Pipeline可以被重复执行
 type Pipeline struct {
 	Name    string
 	Version string
@@ -27,9 +24,21 @@ type Pipeline struct {
 	Envs    EnvList // 为了确保环境变量初始化时按照conf.Envs中切片中的顺序，这里不能采用map
 	Workdir string
 	Stages  []*Stage
-
-	timer      *internal.Timer
-	succeedCnt int
+	Stop    StopPolicy
+	Hooks   *Hooks
+	// LockDir 不为空时，资源组除了进程内的锁之外还会使用该目录中的文件锁，使得同一台机器上的多个流水线进程互斥
+	LockDir string
+	// FailFast 为true时，Stage中有Job失败后立即取消同一Stage中的其他Job，Stage可以单独覆盖
+	FailFast bool
+	// Timeout 整个流水线的执行时间上限，不大于0时表示不限制
+	Timeout time.Duration
+	// LogDir 不为空时，每个Job中Actions和Hooks的输出保存到 JobLogPath 指定的文件中，每个文件最多保存LogMaxSize字节
+	LogDir     string
+	LogMaxSize int64
+
+	shellName string     // WithShell 指定的shell，在 NewPipeline 中解析为 Shell
+	tagFilter *TagFilter // WithTagFilter 指定的tag表达式，MakePipeline 只创建被选中的Job
+	onResult  func(*RunResult)
 
 	logger *slog.Logger
 }
@@ -56,20 +65,69 @@ func WithWorkdir(workdir string) PipelineOptions {
 
 func WithShell(shell string) PipelineOptions {
 	return func(p *Pipeline) {
-		if shell == "" {
-			return
-		}
-		p.Shell[0], p.Shell[1] = getShell(shell)
+		p.shellName = shell
+	}
+}
+
+func WithPipelineHooks(hooks *Hooks) PipelineOptions {
+	return func(p *Pipeline) {
+		p.Hooks = hooks
+	}
+}
+
+func WithLockDir(lockDir string) PipelineOptions {
+	return func(p *Pipeline) {
+		p.LockDir = lockDir
+	}
+}
+
+func WithFailFast(failFast bool) PipelineOptions {
+	return func(p *Pipeline) {
+		p.FailFast = failFast
+	}
+}
+
+func WithPipelineTimeout(timeout time.Duration) PipelineOptions {
+	return func(p *Pipeline) {
+		p.Timeout = timeout
+	}
+}
+
+func WithTagFilter(filter *TagFilter) PipelineOptions {
+	return func(p *Pipeline) {
+		p.tagFilter = filter
+	}
+}
+
+// WithJobLogs 将每个Job的输出保存到logDir中，maxSize不大于0时不限制日志文件的大小
+func WithJobLogs(logDir string, maxSize int64) PipelineOptions {
+	return func(p *Pipeline) {
+		p.LogDir = logDir
+		p.LogMaxSize = maxSize
+	}
+}
+
+// WithResultHandler 每次执行结束后以执行结果调用handler，cron模式下每次触发的执行都会调用，可用于保存执行记录
+func WithResultHandler(handler func(*RunResult)) PipelineOptions {
+	return func(p *Pipeline) {
+		p.onResult = handler
 	}
 }
 
-func NewPipeline(name, version string, opts ...PipelineOptions) *Pipeline {
+func WithStopPolicy(stop StopPolicy) PipelineOptions {
+	return func(p *Pipeline) {
+		p.Stop = stop
+	}
+}
+
+func NewPipeline(name, version string, opts ...PipelineOptions) (*Pipeline, error) {
 	p := &Pipeline{
 		Name:    name,
 		Version: version,
 		Envs:    EnvList{},
 		Stages:  []*Stage{},
-		timer:   &internal.Timer{},
+		Stop:    StopPolicy{Signal: DefaultStopSignal, GracePeriod: DefaultStopGracePeriod},
+		Hooks:   &Hooks{},
 		logger:  slog.Default().With("pipeline", name, "version", version),
 	}
 
@@ -77,23 +135,27 @@ func NewPipeline(name, version string, opts ...PipelineOptions) *Pipeline {
 		opt(p)
 	}
 
-	if p.Shell[0] == "" {
+	if p.shellName != "" {
+		var err error
+		if p.Shell[0], p.Shell[1], err = getShell(p.shellName); err != nil {
+			return nil, err
+		}
+	} else {
 		p.Shell[0], p.Shell[1] = GetDefaultShell()
 	}
 
 	if p.Workdir == "" {
 		pwd, err := os.Getwd()
 		if err != nil {
-			p.logger.Error(fmt.Sprintf("get current workdir failed: %v", err), "error", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("get current workdir failed: %w", err)
 		}
 		p.Workdir = pwd
 	}
 
-	return p
+	return p, nil
 }
 
-func getShell(shell string) (cmd, flag string) {
+func getShell(shell string) (cmd, flag string, err error) {
 	switch shell {
 	case "bash":
 		cmd, flag = "bash", "-c"
@@ -104,7 +166,7 @@ func getShell(shell string) (cmd, flag string) {
 	case "powershell":
 		cmd, flag = "powershell", "-Command"
 	default:
-		panic("Unknown shell: " + shell)
+		err = fmt.Errorf("unknown shell %q: supported values are bash, sh, cmd, powershell", shell)
 	}
 	return
 }
@@ -114,124 +176,59 @@ func (p *Pipeline) AddStage(stage *Stage) *Pipeline {
 	return p
 }
 
-// // 会恢复日志前缀、但不会恢复环境变量。不需要恢复工作目录，因为运行时改动工作目录是脚本中启动的子进程做的，和父进程无关
-// var stackRestore = internal.NewStack()
-
-func (p *Pipeline) preRun(context.Context) Status {
-	// stackRestore.Push(logger.Prefix())
-	// 处理环境变量
-	{
-		// 初始化内置环境变量
-		setupBuiltins(p)
-		// 初始化定制环境变量
-		p.Envs = resolveEnvList(p.Shell, p.Envs)
-		for _, env := range p.Envs {
-			if err := os.Setenv(env.Key, env.Value); err != nil {
-				p.logger.Error(fmt.Sprintf("set env %s=%s for pipeline %s failed: %v", env.Key, env.Value, p.Name, err), "error", err, "key", env.Key, "value", env.Value)
-				return Failed
-			}
-		}
-	}
-
-	// 处理workdir
-	{
-		cmds := findInlineCmd(p.Workdir, p.Shell)
-		for _, cmd := range cmds {
-			output, err := cmd.cmd.CombinedOutput()
-			if err != nil {
-				p.logger.Error(fmt.Sprintf("failed to expr %s: %v", cmd.cmd.String(), err), "error", err, "expr", cmd.cmd.String())
-				continue
-			}
-			// 替换Workdir中cmd.startPos到cmd.endPos的内容为命令的输出
-			p.Workdir = p.Workdir[:cmd.startPos] + strings.TrimSuffix(string(output), "\n") + p.Workdir[cmd.endPos+1:]
-		}
-		// 处理workdir中的环境变量展开
-		p.Workdir = os.Expand(p.Workdir, func(v string) string {
-			if val := os.Getenv(v); val != "" {
-				return val
-			}
-			if val, ok := p.Envs.Find(v); ok {
-				return val
-			}
-			return ""
-		})
-
-		if p.Workdir != "" {
-			if err := os.Chdir(p.Workdir); err != nil {
-				p.logger.Error(fmt.Sprintf("change workdir to %s failed: %v", p.Workdir, err), "error", err, "workdir", p.Workdir)
-				return Failed
-			}
-		}
-	}
-	return Success
-}
-
-func (p *Pipeline) postRun(context.Context) Status {
-	// if prefix, err := stackRestore.Pop(); err == nil {
-	// 	logger.SetPrefix(prefix.(string))
-	// }
-	return Success
+// cronStopTimeout cron模式结束时等待正在进行的执行结束的最长时间。
+// 执行使用的ctx已经被取消，正常情况下会很快结束，只有after等不可取消的hooks卡住时才会超过
+const cronStopTimeout = time.Minute
+
+// Execute 执行流水线，并返回包含每个Stage、Job、Action执行情况的结构化结果。
+// 每次执行都是一个独立的 Run，有独立的环境变量表和工作目录，不会修改当前进程的状态，因此可以在同一进程中并发执行多条流水线。
+// cron模式下会按计划重复执行，直到ctx被取消，最多等待 cronStopTimeout 让正在进行的执行结束后返回最后一次执行的结果；
+// 一次都没有执行时返回 Skiped 的结果。信号由调用者处理，例如收到信号时取消ctx
+func (p *Pipeline) Execute(ctx context.Context) *RunResult {
+	ctx = withStopPolicy(ctx, p.Stop)
+	if p.Cron == "" {
+		result := p.NewRun().Execute(ctx)
+		p.handleResult(result)
+		return result
+	}
+
+	p.logger.Info(fmt.Sprintf("%s@%s {%s}", p.Name, p.Version, p.Cron), "cron", p.Cron)
+	result := newRunResult(p, "", Skiped)
+	mu := &sync.Mutex{}
+	cronDaemon := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	if _, err := cronDaemon.AddFunc(p.Cron, func() { // 失败的任务仍然会继续执行
+		r := p.NewRun().Execute(ctx)
+		p.handleResult(r)
+		mu.Lock()
+		defer mu.Unlock()
+		result = r
+	}); err != nil {
+		p.logger.Error(fmt.Sprintf("invalid cron %q: %v", p.Cron, err), "cron", p.Cron, "error", err)
+		result.Status = Failed
+		result.Error = fmt.Sprintf("invalid cron %q: %v", p.Cron, err)
+		return result
+	}
+	cronDaemon.Start()
+
+	<-ctx.Done()
+	// 正在进行的执行使用同一个ctx，已经被取消，等待它执行完after等hooks
+	c := cronDaemon.Stop()
+	select {
+	case <-c.Done():
+	case <-time.After(cronStopTimeout):
+		p.logger.Warn(fmt.Sprintf("the running pipeline did not finish within %v, stop waiting", cronStopTimeout), "timeout", cronStopTimeout)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return result
 }
 
-func (p *Pipeline) run(ctx context.Context) (status Status) {
-	stageNames := make([]string, len(p.Stages))
-	for i, stage := range p.Stages {
-		stageNames[i] = stage.Name
+func (p *Pipeline) handleResult(result *RunResult) {
+	if p.onResult != nil {
+		p.onResult(result)
 	}
-
-	var cronStr string
-	var cronDaemon *cron.Cron
-	if p.Cron != "" {
-		cronStr = fmt.Sprintf("{%s}", p.Cron)
-		cronDaemon = cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
-	}
-	p.logger.Info(fmt.Sprintf("%s@%s %s (%s): %v", p.Name, p.Version, cronStr, p.Workdir, stageNames), "cron", cronStr, "workdir", p.Workdir, "stages", stageNames)
-
-	work := func() {
-		status = Success
-		defer func() {
-			statistics := fmt.Sprintf("(%d succeed/%d total)", p.succeedCnt, len(p.Stages))
-			p.logger.Info(fmt.Sprintf("%s %s", status, statistics), "status", status.String(), "succeed", p.succeedCnt, "total", len(p.Stages))
-		}()
-		if trace, ok := ctx.Value(internal.TraceKey).(bool); ok && trace {
-			p.timer.Start()
-			defer func() {
-				p.logger.Info(fmt.Sprintf("Cost %v", p.timer.Elapsed()), "cost", p.timer.Elapsed())
-			}()
-		}
-		for _, stage := range p.Stages {
-			if stage.Perform(ctx) == Failed {
-				status = Failed
-				return
-			}
-			p.succeedCnt++
-		}
-	}
-
-	if cronDaemon != nil {
-		cronDaemon.AddFunc(p.Cron, work) // 失败的任务仍然会继续执行
-		cronDaemon.Start()
-
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-		<-sigChan
-		c := cronDaemon.Stop()
-		select {
-		case <-c.Done():
-		case <-time.After(5 * time.Second):
-			p.logger.Warn("wait some job to quit for too long, force quit!")
-		}
-	} else {
-		work()
-	}
-	return
 }
 
 func (p *Pipeline) Run(ctx context.Context) (status Status) {
-	defer p.postRun(ctx)
-	if status = p.preRun(ctx); status != Success {
-		return
-	}
-	status = p.run(ctx)
-	return
+	return p.Execute(ctx).Status
 }
@@ -2,18 +2,31 @@ package pipeline
 
 import (
 	"bytes"
+	"context"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Meha555/go-pipeline/internal/logging"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+func mustNewPipeline(t *testing.T, name, version string, opts ...PipelineOptions) *Pipeline {
+	t.Helper()
+	p, err := NewPipeline(name, version, opts...)
+	if err != nil {
+		t.Fatalf("NewPipeline() error = %v", err)
+	}
+	return p
+}
+
 func TestNewPipelineSetsDefaultShell(t *testing.T) {
 	defaultShell, defaultFlag := GetDefaultShell()
-	p := NewPipeline("test", "1.0.0")
+	p := mustNewPipeline(t, "test", "1.0.0")
 
 	if p.Shell != [2]string{defaultShell, defaultFlag} {
 		t.Fatalf("Shell = %#v, want %#v", p.Shell, [2]string{defaultShell, defaultFlag})
@@ -22,7 +35,7 @@ func TestNewPipelineSetsDefaultShell(t *testing.T) {
 
 func TestNewPipelineWithEmptyShellUsesDefaultShell(t *testing.T) {
 	defaultShell, defaultFlag := GetDefaultShell()
-	p := NewPipeline("test", "1.0.0", WithShell(""))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell(""))
 
 	if p.Shell != [2]string{defaultShell, defaultFlag} {
 		t.Fatalf("Shell = %#v, want %#v", p.Shell, [2]string{defaultShell, defaultFlag})
@@ -30,18 +43,25 @@ func TestNewPipelineWithEmptyShellUsesDefaultShell(t *testing.T) {
 }
 
 func TestNewPipelineSupportsPowerShell(t *testing.T) {
-	p := NewPipeline("test", "1.0.0", WithShell("powershell"))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("powershell"))
 
 	if p.Shell != [2]string{"powershell", "-Command"} {
 		t.Fatalf("Shell = %#v, want powershell command shell", p.Shell)
 	}
 }
 
+func TestNewPipelineRejectsUnknownShell(t *testing.T) {
+	p, err := NewPipeline("test", "1.0.0", WithShell("zsh"))
+	if err == nil || p != nil {
+		t.Fatalf("NewPipeline() = %v, %v, want unknown shell error", p, err)
+	}
+}
+
 func TestPipelineLoggersCarryInheritedContext(t *testing.T) {
 	var buf bytes.Buffer
 	withPipelineTestLogger(t, &buf)
 
-	p := NewPipeline("release", "2.0.0")
+	p := mustNewPipeline(t, "release", "2.0.0")
 	s := NewStage("build", p)
 	j := NewJob("compile", nil, s)
 
@@ -62,6 +82,83 @@ func TestPipelineLoggersCarryInheritedContext(t *testing.T) {
 	}
 }
 
+func TestPipelineRunsNeedsJobBeforePreviousStageCompletes(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	t.Setenv("FAST_OUT", "")
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("slow_job", []*Action{
+		NewAction(p.Shell, "sleep 1 && touch slow.done"),
+	}, build))
+	build.AddJob(NewJob("fast_job", nil, build, WithExports(EnvList{{Key: "FAST_OUT", Value: "fast"}})))
+	test := NewStage("test", p)
+	test.AddJob(NewJob("needs_job", []*Action{
+		NewAction(p.Shell, "test ! -e slow.done && printf '%s' \"$FAST_OUT\" > needs.out"),
+	}, test, WithNeeds([]string{"fast_job"})))
+	p.AddStage(build).AddStage(test)
+
+	if status := p.Run(context.Background()); status != Success {
+		t.Fatalf("Pipeline status = %s, want Success", status)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "needs.out"))
+	if err != nil {
+		t.Fatalf("read needs output: %v", err)
+	}
+	if string(got) != "fast" {
+		t.Fatalf("needs output = %q, want fast", got)
+	}
+}
+
+func TestPipelineSkipsJobWhenNeededJobFails(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("broken_job", []*Action{NewAction(p.Shell, "exit 1")}, build))
+	build.AddJob(NewJob("needs_job", []*Action{
+		NewAction(p.Shell, "touch should-not-exist"),
+	}, build, WithNeeds([]string{"broken_job"})))
+	build.AddJob(NewJob("transitive_job", []*Action{
+		NewAction(p.Shell, "touch should-not-exist-either"),
+	}, build, WithNeeds([]string{"needs_job"})))
+	p.AddStage(build)
+
+	if status := p.Run(context.Background()); status != Failed {
+		t.Fatalf("Pipeline status = %s, want Failed", status)
+	}
+	for _, name := range []string{"should-not-exist", "should-not-exist-either"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("job with failed needs created %s, stat error = %v", name, err)
+		}
+	}
+}
+
+func TestPipelineCancelRunsAfterHooksAndStopsLaterStages(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job", []*Action{
+		NewAction(p.Shell, "sleep 5"),
+	}, build, WithAllowFailure(true), WithHooks(&Hooks{After: []*Action{NewAction(p.Shell, "touch after.done")}})))
+	test := NewStage("test", p)
+	test.AddJob(NewJob("test_job", []*Action{NewAction(p.Shell, "touch should-not-exist")}, test))
+	p.AddStage(build).AddStage(test)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	if status := p.Run(ctx); status != Canceled {
+		t.Fatalf("Pipeline status = %s, want Canceled", status)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "after.done")); err != nil {
+		t.Fatalf("after hook did not run after cancel: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "should-not-exist")); !os.IsNotExist(err) {
+		t.Fatalf("later stage ran after cancel, stat error = %v", err)
+	}
+}
+
 func withPipelineTestLogger(t *testing.T, buf *bytes.Buffer) {
 	t.Helper()
 	oldLogger := log.Logger
@@ -76,3 +173,65 @@ func withPipelineTestLogger(t *testing.T, buf *bytes.Buffer) {
 		t.Fatalf("configure logger: %v", err)
 	}
 }
+
+func TestPipelineExecutePassesResultToHandler(t *testing.T) {
+	var handled []*RunResult
+	p := mustNewPipeline(t, "test", "1.0.0", WithWorkdir(t.TempDir()), WithResultHandler(func(result *RunResult) {
+		handled = append(handled, result)
+	}))
+	s := NewStage("build", p)
+	s.AddJob(NewJob("build_job", nil, s))
+	p.AddStage(s)
+
+	result := p.Execute(context.Background())
+	if len(handled) != 1 || handled[0] != result {
+		t.Fatalf("handler got %d results, want the returned result once", len(handled))
+	}
+}
+
+func TestCronPipelineStopsWhenContextIsCanceled(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithCron("@every 1s"))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "touch started && sleep 30")}, build))
+	p.AddStage(build)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// 等到第一次执行开始后再取消，验证正在进行的执行也会被取消
+		for ctx.Err() == nil {
+			if _, err := os.Stat(filepath.Join(tmpDir, "started")); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	done := make(chan *RunResult, 1)
+	go func() { done <- p.Execute(ctx) }()
+	select {
+	case result := <-done:
+		if result.Status != Canceled {
+			t.Fatalf("Pipeline status = %s, want the canceled run", result.Status)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cron pipeline did not stop after ctx was canceled")
+	}
+}
+
+func TestCronPipelineReportsRunsThatNeverHappened(t *testing.T) {
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()), WithCron("@every 1h"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if result := p.Execute(ctx); result.Status != Skiped {
+		t.Fatalf("Pipeline status = %s, want Skiped when no scheduled run happened", result.Status)
+	}
+
+	invalid := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()), WithCron("every hour"))
+	result := invalid.Execute(context.Background())
+	if result.Status != Failed || !strings.Contains(result.Error, `invalid cron "every hour"`) {
+		t.Fatalf("Pipeline result = %s (%s), want Failed with the invalid cron error", result.Status, result.Error)
+	}
+}
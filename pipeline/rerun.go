@@ -0,0 +1,41 @@
+package pipeline
+
+// Rerun 根据上一次执行的结果准备重新执行没有成功的Job：上一次成功（包括允许的失败）的Job不再执行，
+// 而是沿用上一次的结果并恢复其导出的变量，使得之后的Job得到与上一次相同的变量；
+// 失败、超时、被取消或者没有执行到的Job，以及上一次不存在的Job都会重新执行。返回需要重新执行的Job的数量
+func (p *Pipeline) Rerun(previous *RunResult) int {
+	results := make(map[string]*JobResult)
+	for _, stage := range previous.Stages {
+		for _, job := range stage.Jobs {
+			results[job.Name] = job
+		}
+	}
+	rerun := 0
+	for _, stage := range p.Stages {
+		for _, job := range stage.Jobs {
+			prev, exists := results[job.Name]
+			if !exists || !prev.Status.succeeded() {
+				job.restored = nil
+				rerun++
+				continue
+			}
+			restored := *prev
+			// 连续多次重新执行时，记录的是Job真正执行的那一次
+			if restored.RestoredFrom == "" {
+				restored.RestoredFrom = previous.RunID
+			}
+			job.restored = &restored
+		}
+	}
+	return rerun
+}
+
+// restoredOnly 判断Stage中的Job是否全部沿用上一次执行的结果
+func (s *Stage) restoredOnly() bool {
+	for _, job := range s.Jobs {
+		if job.restored == nil {
+			return false
+		}
+	}
+	return len(s.Jobs) > 0
+}
@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRerunRestoresSucceededJobsAndRerunsFailedJobs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	builds := filepath.Join(tmpDir, "builds")
+	tests := filepath.Join(tmpDir, "tests")
+	ok := filepath.Join(tmpDir, "ok")
+
+	// build -> test，test_job在ok文件存在之前都会失败
+	newRerunPipeline := func() *Pipeline {
+		p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+		build := NewStage("build", p)
+		build.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "echo built >> "+builds)}, build,
+			WithExports(EnvList{{Key: "ARTIFACT", Value: "app.tar.gz"}})))
+		p.AddStage(build)
+		test := NewStage("test", p)
+		test.AddJob(NewJob("test_job", []*Action{
+			NewAction(p.Shell, `echo "$ARTIFACT" >> `+tests),
+			NewAction(p.Shell, "test -f "+ok),
+		}, test))
+		p.AddStage(test)
+		return p
+	}
+
+	first := newRerunPipeline().Execute(context.Background())
+	if first.Status != Failed {
+		t.Fatalf("first run status = %s, want Failed", first.Status)
+	}
+
+	if err := os.WriteFile(ok, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := newRerunPipeline()
+	if n := p.Rerun(first); n != 1 {
+		t.Fatalf("Rerun() = %d, want 1", n)
+	}
+	second := p.Execute(context.Background())
+	if second.Status != Success {
+		t.Fatalf("rerun status = %s, want Success", second.Status)
+	}
+
+	if data, _ := os.ReadFile(builds); strings.Count(string(data), "built") != 1 {
+		t.Fatalf("build_job ran again: %q", data)
+	}
+	if data, _ := os.ReadFile(tests); string(data) != "app.tar.gz\napp.tar.gz\n" {
+		t.Fatalf("test_job saw ARTIFACT %q, want the restored export in both runs", data)
+	}
+	build := second.Stages[0].Jobs[0]
+	if build.RestoredFrom != first.RunID || build.Status != Success || build.Exports["ARTIFACT"] != "app.tar.gz" {
+		t.Fatalf("build_job result = %+v, want restored from %s", build, first.RunID)
+	}
+	if test := second.Stages[1].Jobs[0]; test.RestoredFrom != "" || test.Status != Success {
+		t.Fatalf("test_job result = %+v, want rerun successfully", test)
+	}
+
+	// 再次重新执行时没有需要执行的Job，记录的仍然是Job真正执行的那一次
+	p = newRerunPipeline()
+	if n := p.Rerun(second); n != 0 {
+		t.Fatalf("Rerun() after success = %d, want 0", n)
+	}
+	if got := p.Stages[0].Jobs[0].restored.RestoredFrom; got != first.RunID {
+		t.Fatalf("RestoredFrom = %q, want %s", got, first.RunID)
+	}
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// RunResult 一次流水线执行的结构化结果
+type RunResult struct {
+	Name      string         `json:"name"`
+	Version   string         `json:"version"`
+	RunID     string         `json:"run_id"`
+	Status    Status         `json:"status"`
+	StartTime time.Time      `json:"start_time"`
+	EndTime   time.Time      `json:"end_time"`
+	Duration  time.Duration  `json:"duration"`        // 纳秒
+	Error     string         `json:"error,omitempty"` // 超时时正在执行的Job
+	Stages    []*StageResult `json:"stages"`
+}
+
+// JobsWithStatus 按照执行顺序返回状态为status的Job的名称
+func (r *RunResult) JobsWithStatus(status Status) []string {
+	var names []string
+	for _, stage := range r.Stages {
+		for _, job := range stage.Jobs {
+			if job.Status == status {
+				names = append(names, job.Name)
+			}
+		}
+	}
+	return names
+}
+
+// StageResult 一个Stage的执行结果，未执行到的Stage状态为 Skiped
+type StageResult struct {
+	Name      string        `json:"name"`
+	Status    Status        `json:"status"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+	Jobs      []*JobResult  `json:"jobs"`
+}
+
+// JobResult 一个Job的执行结果
+type JobResult struct {
+	Name         string            `json:"name"`
+	Status       Status            `json:"status"`
+	AllowFailure bool              `json:"allow_failure,omitempty"`
+	Attempts     int               `json:"attempts,omitempty"` // 重新执行整个Job时的执行次数
+	StartTime    time.Time         `json:"start_time"`
+	EndTime      time.Time         `json:"end_time"`
+	Duration     time.Duration     `json:"duration"`
+	Actions      []*ActionResult   `json:"actions"`
+	Exports      map[string]string `json:"exports,omitempty"`
+	RestoredFrom string            `json:"restored_from,omitempty"` // 重新执行失败的Job时，沿用了哪次执行的结果
+	LogFile      string            `json:"log_file,omitempty"`      // 保存Actions和Hooks输出的日志文件
+}
+
+// ActionResult 一个Action的执行结果，包括所有重试
+type ActionResult struct {
+	Command   string        `json:"command"`
+	Status    Status        `json:"status"`
+	ExitCode  int           `json:"exit_code"` // 进程未能启动或被信号杀死时为-1
+	Attempts  int           `json:"attempts"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+	Output    string        `json:"output,omitempty"` // 最后一次执行输出的尾部
+}
+
+// Retries 返回Action的重试次数
+func (r *ActionResult) Retries() int {
+	return max(r.Attempts-1, 0)
+}
+
+func newJobResult(j *Job) *JobResult {
+	return &JobResult{
+		Name:         j.Name,
+		Status:       Unknown,
+		AllowFailure: j.AllowFailure,
+		Actions:      []*ActionResult{},
+	}
+}
+
+// skippedResult 返回没有被执行的Job的结果
+func (r *JobResult) skippedResult() *JobResult {
+	if r.Status == Unknown {
+		r.Status = Skiped
+	}
+	return r
+}
+
+func (r *ActionResult) finish(ctx context.Context, err error) {
+	r.EndTime = time.Now()
+	r.Duration = r.EndTime.Sub(r.StartTime)
+	switch {
+	case err == nil:
+		r.Status = Success
+	case timeoutCause(ctx) != nil, errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.Status = TimedOut
+	case errors.Is(ctx.Err(), context.Canceled):
+		r.Status = Canceled
+	default:
+		r.Status = Failed
+	}
+	if err != nil {
+		r.Error = err.Error()
+		r.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			r.ExitCode = exitErr.ExitCode()
+		}
+	}
+}
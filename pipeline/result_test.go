@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPipelineExecuteReturnsResultTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("broken_job", []*Action{
+		NewAction(p.Shell, "test \"$ACTION_ATTEMPT\" = 2 && exit 3 || exit 1"),
+		NewAction(p.Shell, "echo never"),
+	}, build, WithRetry(&RetryPolicy{Max: 1})))
+	build.AddJob(NewJob("ok_job", nil, build, WithExports(EnvList{{Key: "OUT", Value: "ok"}})))
+	test := NewStage("test", p)
+	test.AddJob(NewJob("test_job", []*Action{NewAction(p.Shell, "echo test")}, test))
+	p.AddStage(build).AddStage(test)
+
+	result := p.Execute(context.Background())
+	if result.Status != Failed || len(result.Stages) != 2 {
+		t.Fatalf("result = %s with %d stages, want Failed with 2 stages", result.Status, len(result.Stages))
+	}
+	if result.Stages[0].Status != Failed || result.Stages[1].Status != Skiped {
+		t.Fatalf("stage statuses = %s/%s, want Failed/Skiped", result.Stages[0].Status, result.Stages[1].Status)
+	}
+	broken := result.Stages[0].Jobs[0]
+	if broken.Name != "broken_job" || broken.Status != Failed || len(broken.Actions) != 2 {
+		t.Fatalf("broken_job result = %+v, want Failed with 2 actions", broken)
+	}
+	if action := broken.Actions[0]; action.Status != Failed || action.ExitCode != 3 || action.Retries() != 1 {
+		t.Fatalf("first action = %+v, want Failed with exit code 3 after 1 retry", action)
+	}
+	if broken.Actions[1].Status != Skiped {
+		t.Fatalf("second action status = %s, want Skiped", broken.Actions[1].Status)
+	}
+	if ok := result.Stages[0].Jobs[1]; ok.Status != Success || ok.Exports["OUT"] != "ok" {
+		t.Fatalf("ok_job result = %+v, want Success with exports", ok)
+	}
+	if job := result.Stages[1].Jobs[0]; job.Status != Skiped {
+		t.Fatalf("test_job status = %s, want Skiped", job.Status)
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("marshal result: %v", err)
+	}
+	if !strings.Contains(string(content), `"status":"Failed"`) {
+		t.Fatalf("json = %s, want status names", content)
+	}
+	var decoded RunResult
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if decoded.Status != Failed || decoded.Stages[1].Status != Skiped {
+		t.Fatalf("decoded statuses = %s/%s, want Failed/Skiped", decoded.Status, decoded.Stages[1].Status)
+	}
+}
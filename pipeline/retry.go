@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const defaultRetryBackoff = time.Second
+
+// maxRetryBackoff 指数退避的上限，避免重试次数很多时等待时间溢出或者过长。Backoff本身更大时以Backoff为准
+const maxRetryBackoff = 5 * time.Minute
+
+// RetryPolicy 失败后的重试策略。默认只重新执行失败的Action，WholeJob为true时从第一个Action开始重新执行整个Job
+type RetryPolicy struct {
+	Max         int           // 最大重试次数，不包括第一次执行
+	Backoff     time.Duration // 第一次重试前的等待时间，之后每次重试翻倍
+	OnExitCodes []int         // 只有退出码在其中时才重试，为空表示任何失败都重试
+	WholeJob    bool          // 重新执行整个Job，只能用于Job的重试策略
+}
+
+// shouldRetry 判断第attempt次执行失败后是否还应该重试
+func (r *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if r == nil || attempt > r.Max {
+		return false
+	}
+	if len(r.OnExitCodes) == 0 {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return slices.Contains(r.OnExitCodes, exitErr.ExitCode())
+}
+
+// delay 返回第attempt次执行失败后到下一次重试前的等待时间（指数退避），不超过 maxRetryBackoff
+func (r *RetryPolicy) delay(attempt int) time.Duration {
+	limit := max(r.Backoff, maxRetryBackoff)
+	delay := r.Backoff
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+// execAction 执行Action，失败时按照Action或者Job的重试策略重新执行。
+// 每次执行时都会通过 ACTION_ATTEMPT 告知Action当前是第几次执行（从1开始），执行结果记录在Job的结果中。
+func (j *jobRun) execAction(ctx context.Context, action *Action, envs []string) (err error) {
+	// Action上的重试策略覆盖Job的重试策略；重新执行整个Job时，Action本身默认不重试
+	retry := j.Retry
+	if action.Retry != nil {
+		retry = action.Retry
+	} else if retry != nil && retry.WholeJob {
+		retry = nil
+	}
+	result := &ActionResult{Command: action.String(), StartTime: time.Now()}
+	j.result.Actions = append(j.result.Actions, result)
+	var output *tailBuffer
+	defer func() {
+		result.Output = output.String()
+		result.finish(ctx, err)
+	}()
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		// 只保留最后一次执行的输出
+		output = newTailBuffer(maxActionOutput)
+		if err = action.exec(ctx, append(slices.Clip(envs), envLine("ACTION_ATTEMPT", strconv.Itoa(attempt))), output); err == nil {
+			return
+		}
+		// 超时或者被取消时重试没有意义
+		if ctx.Err() != nil || !retry.shouldRetry(attempt, err) {
+			return
+		}
+		delay := retry.delay(attempt)
+		j.logger.Warn(fmt.Sprintf("action (%s) failed on attempt %d/%d: %v, retry in %v", action, attempt, retry.Max+1, err, delay), "error", err, "action", action.String(), "attempt", attempt, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobRetriesFailedActionWithAttemptNumber(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("flaky_job", []*Action{
+		NewAction(p.Shell, "printf '%s,' \"$ACTION_ATTEMPT\" >> attempts.out && test \"$ACTION_ATTEMPT\" = 3"),
+	}, build, WithRetry(&RetryPolicy{Max: 3})))
+	p.AddStage(build)
+
+	if status := p.Run(context.Background()); status != Success {
+		t.Fatalf("Pipeline status = %s, want Success", status)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "attempts.out"))
+	if err != nil {
+		t.Fatalf("read attempts output: %v", err)
+	}
+	if string(got) != "1,2,3," {
+		t.Fatalf("attempts = %q, want 1,2,3,", got)
+	}
+}
+
+func TestJobDoesNotRetryUnlistedExitCode(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("broken_job", []*Action{
+		NewAction(p.Shell, "printf x >> attempts.out && exit 2"),
+	}, build, WithRetry(&RetryPolicy{Max: 3, OnExitCodes: []int{1}})))
+	p.AddStage(build)
+
+	if status := p.Run(context.Background()); status != Failed {
+		t.Fatalf("Pipeline status = %s, want Failed", status)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "attempts.out"))
+	if err != nil {
+		t.Fatalf("read attempts output: %v", err)
+	}
+	if string(got) != "x" {
+		t.Fatalf("attempts = %q, want a single attempt", got)
+	}
+}
+
+func TestRetryDelayIsCapped(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{time.Second, 1, time.Second},
+		{time.Second, 4, 8 * time.Second},
+		{time.Second, 10, maxRetryBackoff},
+		// 位移会溢出为负数或者0的重试次数
+		{time.Second, 64, maxRetryBackoff},
+		{time.Second, 1000, maxRetryBackoff},
+		{time.Hour, 3, time.Hour},
+		{0, 100, 0},
+	}
+	for _, tt := range tests {
+		r := &RetryPolicy{Backoff: tt.backoff}
+		if got := r.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) with backoff %v = %v, want %v", tt.attempt, tt.backoff, got, tt.want)
+		}
+	}
+}
+
+func TestJobRetryRerunsWholeJob(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("flaky_job", []*Action{
+		NewAction(p.Shell, "printf 'prepare%s,' \"$JOB_ATTEMPT\" >> attempts.out"),
+		NewAction(p.Shell, "printf 'check%s,' \"$JOB_ATTEMPT\" >> attempts.out && test \"$JOB_ATTEMPT\" = 2"),
+	}, build, WithRetry(&RetryPolicy{Max: 2, WholeJob: true})))
+	p.AddStage(build)
+
+	result := p.Execute(context.Background())
+	if result.Status != Success {
+		t.Fatalf("Pipeline status = %s, want Success", result.Status)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "attempts.out"))
+	if err != nil {
+		t.Fatalf("read attempts output: %v", err)
+	}
+	if string(got) != "prepare1,check1,prepare2,check2," {
+		t.Fatalf("attempts = %q, want the whole job executed twice", got)
+	}
+	job := result.Stages[0].Jobs[0]
+	if job.Attempts != 2 || len(job.Actions) != 2 || job.Actions[1].Status != Success || job.Actions[1].Attempts != 1 {
+		t.Fatalf("job result = %+v, want 2 attempts with the last attempt's actions", job)
+	}
+}
+
+func TestActionRetryOverridesJobRetry(t *testing.T) {
+	tmpDir := t.TempDir()
+	restoreWdAfterTest(t)
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	flaky := NewAction(p.Shell, "printf x >> flaky.out && test \"$ACTION_ATTEMPT\" = 3")
+	flaky.Retry = &RetryPolicy{Max: 2}
+	build.AddJob(NewJob("flaky_job", []*Action{flaky}, build))
+	broken := NewAction(p.Shell, "printf x >> broken.out && exit 1")
+	broken.Retry = &RetryPolicy{}
+	build.AddJob(NewJob("broken_job", []*Action{broken}, build, WithRetry(&RetryPolicy{Max: 3})))
+	p.AddStage(build)
+
+	result := p.Execute(context.Background())
+	want := map[string]struct {
+		status Status
+		output string
+	}{"flaky_job": {Success, "xxx"}, "broken_job": {Failed, "x"}}
+	for _, job := range result.Stages[0].Jobs {
+		got, err := os.ReadFile(filepath.Join(tmpDir, strings.TrimSuffix(job.Name, "_job")+".out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := want[job.Name]; job.Status != w.status || string(got) != w.output {
+			t.Errorf("job %s = %s with attempts %q, want %s with %q", job.Name, job.Status, got, w.status, w.output)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package pipeline
 import (
 	"context"
 	"fmt"
-	"os"
 	"os/exec"
 	"strings"
 
@@ -13,16 +12,16 @@ import (
 type Rule = parser.RuleConf
 type RuleOn = parser.RuleOn
 
-func (j *Job) matchRules(ctx context.Context, envs []string) bool {
+func (j *Job) matchRules(ctx context.Context, env *runEnv, envs []string) bool {
 	for _, rule := range j.Rules {
-		if j.matchRule(ctx, rule, envs) {
+		if j.matchRule(ctx, rule, env, envs) {
 			return true
 		}
 	}
 	return false
 }
 
-func (j *Job) matchRule(ctx context.Context, rule Rule, envs []string) bool {
+func (j *Job) matchRule(ctx context.Context, rule Rule, env *runEnv, envs []string) bool {
 	if rule.On.Default {
 		return true
 	}
@@ -35,16 +34,16 @@ func (j *Job) matchRule(ctx context.Context, rule Rule, envs []string) bool {
 	}
 	// 如果是条件是变量，则直接验证变量值
 	if name, ok := variableReferenceName(condition); ok {
-		return truthy(lookupEnv(name, envs))
+		return truthy(lookupEnv(name, env, envs))
 	}
 	// 否则认为条件是shell命令，以shell命令执行结果作为条件值
-	return j.runRuleCommand(ctx, condition, envs)
+	return j.runRuleCommand(ctx, condition, env, envs)
 }
 
-func (j *Job) runRuleCommand(ctx context.Context, command string, envs []string) bool {
+func (j *Job) runRuleCommand(ctx context.Context, command string, env *runEnv, envs []string) bool {
 	cmd := ShellCommandContext(ctx, j.s.p.Shell[0], j.s.p.Shell[1], command)
-	cmd.Env = append(os.Environ(), envs...)
-	cmd.Dir = j.s.p.Workdir
+	cmd.Env = env.environ(envs...)
+	cmd.Dir = env.dir()
 	if err := cmd.Run(); err != nil {
 		if _, ok := err.(*exec.ExitError); !ok {
 			j.logger.Warn(fmt.Sprintf("rule command %q failed to start: %v", command, err), "command", command, "error", err)
@@ -75,14 +74,14 @@ func variableReferenceName(cond string) (string, bool) {
 	return name, isValidEnvKey(name)
 }
 
-func lookupEnv(name string, overrides []string) string {
+func lookupEnv(name string, env *runEnv, overrides []string) string {
 	for i := len(overrides) - 1; i >= 0; i-- {
 		key, value, ok := strings.Cut(overrides[i], "=")
 		if ok && key == name {
 			return value
 		}
 	}
-	return os.Getenv(name)
+	return env.getenv(name)
 }
 
 func truthy(value string) bool {
@@ -10,7 +10,7 @@ import (
 func TestJobRulesSkipWhenVariableIsFalse(t *testing.T) {
 	tmpDir := t.TempDir()
 	restoreWdAfterTest(t)
-	p := NewPipeline("test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithEnvs(EnvList{{Key: "RUN_JOB", Value: "false"}}))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithEnvs(EnvList{{Key: "RUN_JOB", Value: "false"}}))
 	stage := NewStage("build", p)
 	stage.AddJob(NewJob("build_job", []*Action{
 		NewAction(p.Shell, "touch should-not-exist"),
@@ -28,7 +28,7 @@ func TestJobRulesSkipWhenVariableIsFalse(t *testing.T) {
 func TestJobRulesRunWhenShellCommandReturnsZero(t *testing.T) {
 	tmpDir := t.TempDir()
 	restoreWdAfterTest(t)
-	p := NewPipeline("test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
 	stage := NewStage("build", p)
 	stage.AddJob(NewJob("build_job", []*Action{
 		NewAction(p.Shell, "touch should-exist"),
@@ -46,7 +46,7 @@ func TestJobRulesRunWhenShellCommandReturnsZero(t *testing.T) {
 func TestJobRulesSkipWhenShellCommandReturnsNonZero(t *testing.T) {
 	tmpDir := t.TempDir()
 	restoreWdAfterTest(t)
-	p := NewPipeline("test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
 	stage := NewStage("build", p)
 	stage.AddJob(NewJob("build_job", []*Action{
 		NewAction(p.Shell, "touch should-not-exist"),
@@ -0,0 +1,283 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Meha555/go-pipeline/internal"
+)
+
+// Run 流水线的一次执行，持有本次执行的运行环境、计数器、计时器以及各个Stage和Job的执行状态。
+// 每个Run只能执行一次，再次执行流水线需要通过 Pipeline.NewRun 创建新的Run
+type Run struct {
+	ID string // 本次执行的唯一标识，通过内置变量 PIPELINE_RUN_ID 传递给Action
+
+	p          *Pipeline
+	env        *runEnv
+	stages     []*stageRun
+	timer      *internal.Timer
+	succeedCnt int
+	capacity   *capacity // 所有Stage共享的并发限制，由 internal.JobsKey 指定
+	lockDir    string    // 资源组文件锁所在的目录，为空时只使用进程内的锁
+	logger     *slog.Logger
+}
+
+// NewRun 为流水线创建一次新的执行
+func (p *Pipeline) NewRun() *Run {
+	r := &Run{
+		ID:    newRunID(),
+		p:     p,
+		env:   newRunEnv(),
+		timer: &internal.Timer{},
+	}
+	r.logger = p.logger.With("run_id", r.ID)
+	for _, stage := range p.Stages {
+		r.stages = append(r.stages, newStageRun(stage, r))
+	}
+	return r
+}
+
+// newRunID 生成形如 20250102030405-1a2b3c4d 的执行ID，前缀便于按时间排序，随机后缀保证同一秒内的多次执行也不会重复
+func newRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), hex.EncodeToString(suffix))
+}
+
+// // 会恢复日志前缀、但不会恢复环境变量。不需要恢复工作目录，因为运行时改动工作目录是脚本中启动的子进程做的，和父进程无关
+// var stackRestore = internal.NewStack()
+
+// preRun 初始化本次执行的运行环境：解析流水线的环境变量和工作目录，但不修改当前进程的环境变量和工作目录
+func (r *Run) preRun(ctx context.Context) Status {
+	p, env := r.p, r.env
+	// stackRestore.Push(logger.Prefix())
+	// 处理环境变量
+	{
+		// 初始化内置环境变量。必须先于定制环境变量设置，从而使得自定义变量可以引用内置变量
+		for _, builtin := range builtinVars(p, r.ID) {
+			env.set(builtin.Key, builtin.Value)
+		}
+		// 初始化定制环境变量
+		for _, item := range resolveEnvList(env, p.Shell, p.Envs) {
+			env.set(item.Key, item.Value)
+		}
+	}
+
+	// 处理workdir
+	{
+		workdir := p.Workdir
+		cmds := findInlineCmd(workdir, p.Shell)
+		for _, cmd := range cmds {
+			cmd.cmd.Env = env.environ()
+			output, err := cmd.cmd.CombinedOutput()
+			if err != nil {
+				r.logger.Error(fmt.Sprintf("failed to expr %s: %v", cmd.cmd.String(), err), "error", err, "expr", cmd.cmd.String())
+				continue
+			}
+			// 替换Workdir中cmd.startPos到cmd.endPos的内容为命令的输出
+			workdir = workdir[:cmd.startPos] + strings.TrimSuffix(string(output), "\n") + workdir[cmd.endPos+1:]
+		}
+		// 处理workdir中的环境变量展开
+		workdir = os.Expand(workdir, env.getenv)
+
+		if workdir != "" {
+			if abs, err := filepath.Abs(workdir); err == nil {
+				workdir = abs
+			}
+			if info, err := os.Stat(workdir); err != nil || !info.IsDir() {
+				if err == nil {
+					err = fmt.Errorf("not a directory")
+				}
+				r.logger.Error(fmt.Sprintf("change workdir to %s failed: %v", workdir, err), "error", err, "workdir", workdir)
+				return Failed
+			}
+			env.workdir = workdir
+			env.set("PIPELINE_WORKDIR", workdir)
+		}
+	}
+
+	// 处理资源组文件锁所在的目录，相对路径相对于工作目录
+	if p.LockDir != "" {
+		lockDir := os.Expand(p.LockDir, env.getenv)
+		if !filepath.IsAbs(lockDir) && env.dir() != "" {
+			lockDir = filepath.Join(env.dir(), lockDir)
+		}
+		r.lockDir = lockDir
+	}
+	return Success
+}
+
+func (r *Run) postRun(context.Context) Status {
+	// if prefix, err := stackRestore.Pop(); err == nil {
+	// 	logger.SetPrefix(prefix.(string))
+	// }
+	return Success
+}
+
+// Execute 执行流水线，并返回包含每个Stage、Job、Action执行情况的结构化结果
+func (r *Run) Execute(ctx context.Context) (result *RunResult) {
+	ctx = withRunEnv(ctx, r.env)
+	if jobs, ok := ctx.Value(internal.JobsKey).(int); ok {
+		r.capacity = newCapacity(jobs)
+	}
+	// 流水线超时后取消ctx，hooks和各个Stage都计入流水线的执行时间
+	if r.p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, r.p.Timeout, &timeoutError{unit: "pipeline", timeout: r.p.Timeout})
+		defer cancel()
+	}
+	defer r.postRun(ctx)
+	if status := r.preRun(ctx); status != Success {
+		return newRunResult(r.p, r.ID, status)
+	}
+
+	stageNames := make([]string, len(r.stages))
+	for i, stage := range r.stages {
+		stageNames[i] = stage.Name
+	}
+	r.logger.Info(fmt.Sprintf("%s@%s [%s] (%s): %v", r.p.Name, r.p.Version, r.ID, r.env.dir(), stageNames), "workdir", r.env.dir(), "stages", stageNames)
+
+	result = newRunResult(r.p, r.ID, Success)
+	defer func() {
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		statistics := fmt.Sprintf("(%d succeed/%d total)", r.succeedCnt, len(r.stages))
+		r.logger.Info(fmt.Sprintf("%s %s", result.Status, statistics), "status", result.Status.String(), "succeed", r.succeedCnt, "total", len(r.stages))
+	}()
+	if trace, ok := ctx.Value(internal.TraceKey).(bool); ok && trace {
+		r.timer.Start()
+		defer func() {
+			r.logger.Info(fmt.Sprintf("Cost %v", r.timer.Elapsed()), "cost", r.timer.Elapsed())
+		}()
+	}
+	if err := r.linkNeeds(); err != nil {
+		r.logger.Error(fmt.Sprintf("link needs failed: %v", err), "error", err)
+		result.Status = Failed
+		return
+	}
+
+	r.p.Hooks.runBefore(ctx, r.logger, nil)
+	result.Status = r.performStages(ctx)
+	// 流水线的结果hooks可以通过 PIPELINE_STATUS 得知流水线的执行结果
+	r.p.Hooks.runAfter(context.WithoutCancel(ctx), r.logger, nil)
+	r.p.Hooks.runOutcome(context.WithoutCancel(ctx), r.logger, result.Status, []string{envLine("PIPELINE_STATUS", result.Status.String())})
+	var jobs []*jobRun
+	for _, stage := range r.stages {
+		if stage.result != nil {
+			result.Stages = append(result.Stages, stage.result)
+		} else {
+			result.Stages = append(result.Stages, stage.skippedResult())
+		}
+		jobs = append(jobs, stage.jobs...)
+	}
+	// 报告超时时正在执行的Job，便于定位是哪个Job耗尽了时间
+	if result.Error = timeoutMessage(jobs); result.Error != "" {
+		r.logger.Error(result.Error)
+	}
+	return
+}
+
+func newRunResult(p *Pipeline, runID string, status Status) *RunResult {
+	now := time.Now()
+	return &RunResult{
+		Name:      p.Name,
+		Version:   p.Version,
+		RunID:     runID,
+		Status:    status,
+		StartTime: now,
+		EndTime:   now,
+		Stages:    []*StageResult{},
+	}
+}
+
+// performStages 依次执行各个Stage，返回流水线的状态。
+// 有Stage失败或者流水线被取消后，只执行执行条件与当前状态相符的Stage（例如清理资源的Stage）
+func (r *Run) performStages(ctx context.Context) (status Status) {
+	abort := make(chan struct{})
+	needsWg := &sync.WaitGroup{}
+	r.scheduleNeeds(ctx, abort, needsWg)
+	defer func() {
+		// 释放仍在等待依赖的Job，并等待已经开始执行的Job结束
+		close(abort)
+		needsWg.Wait()
+	}()
+	status = Success
+	for _, stage := range r.stages {
+		if ctx.Err() != nil && status.succeeded() {
+			status = interruptedStatus(ctx)
+		}
+		if !stage.When.match(status) {
+			// 流水线超时后剩余的Stage没有机会执行，标记为超时而不是跳过
+			skipStatus := Skiped
+			if ctx.Err() != nil && interruptedStatus(ctx) == TimedOut {
+				skipStatus = TimedOut
+			}
+			stage.skip(status, skipStatus)
+			continue
+		}
+		stageCtx := ctx
+		if ctx.Err() != nil {
+			// 流水线被取消或超时后仍然执行的Stage（例如清理资源）不能继承已经结束的ctx
+			stageCtx = context.WithoutCancel(ctx)
+		}
+		stageStatus := stage.perform(stageCtx)
+		if !stageStatus.failed() {
+			r.succeedCnt++
+		}
+		status = worseStatus(status, stageStatus)
+	}
+	return
+}
+
+// linkNeeds 将Job声明的needs解析为本次执行中的Job
+func (r *Run) linkNeeds() error {
+	jobs := make(map[string]*jobRun)
+	for _, stage := range r.stages {
+		for _, job := range stage.jobs {
+			jobs[job.Name] = job
+		}
+	}
+	for _, stage := range r.stages {
+		for _, job := range stage.jobs {
+			for _, need := range job.Needs {
+				needJob, exists := jobs[need]
+				if !exists {
+					// 被依赖的Job可能被skips跳过了，此时视为没有该依赖
+					job.logger.Warn(fmt.Sprintf("job %s needs job %s which is not in pipeline, ignored it", job.Name, need), "need", need)
+					continue
+				}
+				if needJob == job {
+					return fmt.Errorf("job %s needs itself", job.Name)
+				}
+				job.needs = append(job.needs, needJob)
+			}
+		}
+	}
+	return nil
+}
+
+// scheduleNeeds 为每个声明了needs的Job启动一个调度协程，在其依赖的Job全部完成后立即执行该Job，
+// 而不必等待之前的Stage全部结束。abort关闭后，仍在等待依赖的Job将不再执行。
+func (r *Run) scheduleNeeds(ctx context.Context, abort <-chan struct{}, wg *sync.WaitGroup) {
+	for _, stage := range r.stages {
+		for _, job := range stage.jobs {
+			// 有执行条件的Stage要等到确定执行时才调度，见 stageRun.perform
+			if job.Needs == nil || stage.When != OnSuccess {
+				continue
+			}
+			wg.Add(1)
+			go func(j *jobRun) {
+				defer wg.Done()
+				j.doAfterNeeds(ctx, abort)
+			}(job)
+		}
+	}
+}
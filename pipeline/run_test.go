@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPipelineCanBeExecutedRepeatedlyAndConcurrently(t *testing.T) {
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job", []*Action{
+		NewAction(p.Shell, `printf '%s' "$PIPELINE_RUN_ID" > "run-$PIPELINE_RUN_ID.out"`),
+	}, build, WithExports(EnvList{{Key: "BUILT_BY", Value: "$PIPELINE_RUN_ID"}})))
+	build.AddJob(NewJob("needs_job", []*Action{
+		NewAction(p.Shell, `test "$BUILT_BY" = "$PIPELINE_RUN_ID"`),
+	}, build, WithNeeds([]string{"build_job"})))
+	p.AddStage(build)
+
+	results := []*RunResult{p.Execute(context.Background()), p.Execute(context.Background())}
+	concurrent := make([]*RunResult, 2)
+	wg := &sync.WaitGroup{}
+	for i := range concurrent {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			concurrent[i] = p.Execute(context.Background())
+		}()
+	}
+	wg.Wait()
+	results = append(results, concurrent...)
+
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if result.Status != Success {
+			t.Fatalf("run %s status = %s, want Success", result.RunID, result.Status)
+		}
+		if result.RunID == "" || seen[result.RunID] {
+			t.Fatalf("run ID %q is empty or not unique", result.RunID)
+		}
+		seen[result.RunID] = true
+		if jobs := result.Stages[0].Jobs; len(jobs) != 2 || len(jobs[0].Actions) != 1 || len(jobs[1].Actions) != 1 {
+			t.Fatalf("run %s result has %d jobs, want 2 jobs with one action each", result.RunID, len(jobs))
+		}
+		got, err := os.ReadFile(filepath.Join(tmpDir, "run-"+result.RunID+".out"))
+		if err != nil {
+			t.Fatalf("read run output: %v", err)
+		}
+		if string(got) != result.RunID {
+			t.Fatalf("PIPELINE_RUN_ID = %q, want %q", got, result.RunID)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// runEnv 一次流水线执行的环境变量表和工作目录。
+// 流水线不会修改当前进程的环境变量和工作目录，而是通过 exec.Cmd 的 Env 和 Dir 传递给子进程，
+// 因此同一进程中可以同时执行多条流水线。
+type runEnv struct {
+	mu      sync.RWMutex
+	host    []string // 开始执行时当前进程的环境变量
+	vars    EnvList  // 内置变量、流水线的envs，以及各Stage导入的exports，同名变量以后设置的为准
+	workdir string   // 为空时表示当前进程的工作目录
+}
+
+func newRunEnv() *runEnv {
+	return &runEnv{host: os.Environ()}
+}
+
+// lookup 查找变量，先查找流水线设置的变量，再回退到当前进程的环境变量
+func (e *runEnv) lookup(key string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for i := len(e.vars) - 1; i >= 0; i-- {
+		if e.vars[i].Key == key {
+			return e.vars[i].Value, true
+		}
+	}
+	for i := len(e.host) - 1; i >= 0; i-- {
+		if k, v, ok := cutEnvLine(e.host[i]); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// cutEnvLine 将 KEY=VALUE 切分为变量名和值。
+// Windows上存在形如 =C:=C:\ 的变量，变量名本身以'='开头，因此从第二个字符开始查找分隔符
+func cutEnvLine(line string) (key, value string, ok bool) {
+	if line == "" {
+		return "", "", false
+	}
+	i := strings.IndexByte(line[1:], '=')
+	if i < 0 {
+		return "", "", false
+	}
+	return line[:i+1], line[i+2:], true
+}
+
+// getenv 与 os.Getenv 类似，变量不存在时返回空字符串
+func (e *runEnv) getenv(key string) string {
+	value, _ := e.lookup(key)
+	return value
+}
+
+func (e *runEnv) set(key, value string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.vars {
+		if e.vars[i].Key == key {
+			e.vars[i].Value = value
+			return
+		}
+	}
+	e.vars.Append(key, value)
+}
+
+// environ 返回传递给子进程的环境变量，extra中的变量优先级最高
+func (e *runEnv) environ(extra ...string) []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	env := slices.Clip(slices.Clone(e.host))
+	for _, v := range e.vars {
+		env = append(env, envLine(v.Key, v.Value))
+	}
+	// exec.Cmd 在变量重复时会使用最后一个值
+	return append(env, extra...)
+}
+
+func (e *runEnv) dir() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.workdir
+}
+
+type runEnvKey struct{}
+
+func withRunEnv(ctx context.Context, env *runEnv) context.Context {
+	return context.WithValue(ctx, runEnvKey{}, env)
+}
+
+// runEnvFrom 返回ctx中携带的运行环境。单独执行Stage、Job或Action时没有运行环境，此时使用当前进程的环境变量和工作目录
+func runEnvFrom(ctx context.Context) *runEnv {
+	if env, ok := ctx.Value(runEnvKey{}).(*runEnv); ok {
+		return env
+	}
+	return newRunEnv()
+}
@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentPipelinesHaveIsolatedEnvAndWorkdir(t *testing.T) {
+	oldWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("get working directory: %v", err)
+	}
+	t.Setenv("RUN_VALUE", "")
+	t.Setenv("EXPORTED", "")
+
+	dirs := []string{t.TempDir(), t.TempDir()}
+	pipes := make([]*Pipeline, len(dirs))
+	for i, dir := range dirs {
+		p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(dir), WithEnvs(EnvList{{Key: "RUN_VALUE", Value: filepath.Base(dir)}}))
+		build := NewStage("build", p)
+		build.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "sleep 0.2")}, build, WithExports(EnvList{{Key: "EXPORTED", Value: "$RUN_VALUE"}})))
+		test := NewStage("test", p)
+		test.AddJob(NewJob("test_job", []*Action{
+			NewAction(p.Shell, `printf '%s,%s,%s,%s' "$RUN_VALUE" "$EXPORTED" "$STAGE_NAME" "$(pwd)" > env.out`),
+		}, test))
+		p.AddStage(build).AddStage(test)
+		pipes[i] = p
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, p := range pipes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status := p.Run(context.Background()); status != Success {
+				t.Errorf("Pipeline status = %s, want Success", status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, dir := range dirs {
+		got, err := os.ReadFile(filepath.Join(dir, "env.out"))
+		if err != nil {
+			t.Fatalf("read env output: %v", err)
+		}
+		realDir, _ := filepath.EvalSymlinks(dir)
+		name := filepath.Base(dir)
+		if want := name + "," + name + ",test," + realDir; string(got) != want {
+			t.Fatalf("env output = %q, want %q", got, want)
+		}
+	}
+	if wd, _ := os.Getwd(); wd != oldWd {
+		t.Fatalf("working directory changed to %s", wd)
+	}
+	for _, key := range []string{"RUN_VALUE", "EXPORTED", "STAGE_NAME", "PIPELINE_NAME"} {
+		if got := os.Getenv(key); got != "" {
+			t.Fatalf("%s leaked to process env: %q", key, got)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Selection 选择流水线中要执行的部分。Only和Skip中的名称可以是Stage名或Job名，支持 path.Match 风格的通配符，
+// 矩阵Job既可以通过变体名选择，也可以通过展开前的Job名选择全部变体
+type Selection struct {
+	Only       []string // 只执行名称匹配的Stage或Job，为空时表示全部
+	Skip       []string // 不执行名称匹配的Stage或Job，优先于其他选择条件
+	FromStage  string   // 从该Stage开始执行
+	UntilStage string   // 执行完该Stage后结束
+	WithNeeds  bool     // 自动包含被选中的Job直接或间接依赖的Job，即使它们不在选择范围内
+}
+
+// Select 按照sel裁剪流水线，只保留被选中的Job以及包含它们的Stage。
+// 没有Job的Stage和 when: always 的Stage只有在其名称被排除（不在Stage范围内或者匹配Skip）时才会被去掉。
+// 被依赖的Job没有被选中时，依赖关系被忽略，与被 skips 跳过的Job一样
+func (p *Pipeline) Select(sel Selection) error {
+	if len(p.Stages) == 0 {
+		return errors.New("no jobs selected")
+	}
+	for _, pattern := range slices.Concat(sel.Only, sel.Skip) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	from, until := 0, len(p.Stages)-1
+	if sel.FromStage != "" {
+		if from = p.stageIndex(sel.FromStage); from < 0 {
+			return fmt.Errorf("unknown stage %q", sel.FromStage)
+		}
+	}
+	if sel.UntilStage != "" {
+		if until = p.stageIndex(sel.UntilStage); until < 0 {
+			return fmt.Errorf("unknown stage %q", sel.UntilStage)
+		}
+	}
+	if from > until {
+		return fmt.Errorf("stage %s comes after stage %s", sel.FromStage, sel.UntilStage)
+	}
+
+	var errs []error
+	for _, pattern := range sel.Only {
+		if !p.matches(pattern) {
+			errs = append(errs, fmt.Errorf("no stage or job matches %q", pattern))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, pattern := range sel.Skip {
+		if !p.matches(pattern) {
+			p.logger.Warn(fmt.Sprintf("no stage or job matches skip pattern %q", pattern), "pattern", pattern)
+		}
+	}
+
+	jobs := make(map[string]*Job)
+	selected := make(map[*Job]bool)
+	total := 0
+	for i, stage := range p.Stages {
+		for _, job := range stage.Jobs {
+			total++
+			jobs[job.Name] = job
+			if i < from || i > until || matchJob(sel.Skip, stage, job) {
+				continue
+			}
+			if len(sel.Only) == 0 || matchJob(sel.Only, stage, job) {
+				selected[job] = true
+			}
+		}
+	}
+	if sel.WithNeeds {
+		// 按广度优先的顺序加入依赖的Job，被跳过的Job不会被加入
+		queue := make([]*Job, 0, len(selected))
+		for _, stage := range p.Stages {
+			for _, job := range stage.Jobs {
+				if selected[job] {
+					queue = append(queue, job)
+				}
+			}
+		}
+		for len(queue) > 0 {
+			job := queue[0]
+			queue = queue[1:]
+			for _, name := range job.Needs {
+				need, exists := jobs[name]
+				if !exists || selected[need] || matchJob(sel.Skip, need.s, need) {
+					continue
+				}
+				p.logger.Info(fmt.Sprintf("job %s is included because job %s needs it", need.Name, job.Name), "job", need.Name, "needed_by", job.Name)
+				selected[need] = true
+				queue = append(queue, need)
+			}
+		}
+	}
+
+	if total > 0 && len(selected) == 0 {
+		return errors.New("no jobs selected")
+	}
+	stages := make([]*Stage, 0, len(p.Stages))
+	for i, stage := range p.Stages {
+		stageJobs := make([]*Job, 0, len(stage.Jobs))
+		for _, job := range stage.Jobs {
+			if selected[job] {
+				stageJobs = append(stageJobs, job)
+			}
+		}
+		if len(stageJobs) == 0 {
+			// 没有Job的Stage（例如只有hooks的Stage）只有在其本身不在范围内或者被跳过时才去掉
+			if i < from || i > until || slices.ContainsFunc(sel.Skip, func(pattern string) bool { return matchName(pattern, stage.Name) }) {
+				continue
+			}
+			// 全部Job都没有被选中的Stage不再执行，但 when: always 的Stage（例如清理）仍然执行其hooks
+			if len(stage.Jobs) > 0 && stage.When != Always {
+				continue
+			}
+		}
+		stage.Jobs = stageJobs
+		stages = append(stages, stage)
+	}
+	p.Stages = stages
+	p.logger.Info(fmt.Sprintf("selected %d of %d jobs", len(selected), total), "selected", len(selected), "total", total)
+	return nil
+}
+
+func (p *Pipeline) stageIndex(name string) int {
+	return slices.IndexFunc(p.Stages, func(stage *Stage) bool { return stage.Name == name })
+}
+
+// matches 判断是否有Stage或Job匹配pattern
+func (p *Pipeline) matches(pattern string) bool {
+	for _, stage := range p.Stages {
+		if matchName(pattern, stage.Name) {
+			return true
+		}
+		for _, job := range stage.Jobs {
+			if matchJob([]string{pattern}, stage, job) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchJob 判断Job本身、矩阵Job展开前的名称或者所属的Stage是否匹配patterns中的任意一个
+func matchJob(patterns []string, stage *Stage, job *Job) bool {
+	names := []string{stage.Name, job.Name}
+	if base := job.matrixBaseName(); base != "" {
+		names = append(names, base)
+	}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if matchName(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchName(pattern, name string) bool {
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// matrixBaseName 返回矩阵Job展开前的名称，不是矩阵Job时返回空字符串。变体名的规则见 expandMatrix
+func (j *Job) matrixBaseName() string {
+	if len(j.Matrix) == 0 {
+		return ""
+	}
+	values := make([]string, 0, len(j.Matrix))
+	for _, item := range j.Matrix {
+		values = append(values, item.Value)
+	}
+	base, found := strings.CutSuffix(j.Name, "-"+strings.Join(values, "-"))
+	if !found {
+		return ""
+	}
+	return base
+}
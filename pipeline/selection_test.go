@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newSelectionPipeline 创建 build -> test -> deploy 三个Stage的流水线，其中build_job是矩阵Job
+func newSelectionPipeline(t *testing.T) *Pipeline {
+	t.Helper()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job-Debug", nil, build, WithMatrix(EnvList{{Key: "BUILD_TYPE", Value: "Debug"}})))
+	build.AddJob(NewJob("build_job-Release", nil, build, WithMatrix(EnvList{{Key: "BUILD_TYPE", Value: "Release"}})))
+	build.AddJob(NewJob("docs_job", nil, build))
+	p.AddStage(build)
+	test := NewStage("test", p)
+	test.AddJob(NewJob("unit_test", nil, test, WithNeeds([]string{"build_job-Release"})))
+	test.AddJob(NewJob("lint_test", nil, test))
+	p.AddStage(test)
+	deploy := NewStage("deploy", p)
+	deploy.AddJob(NewJob("deploy_job", nil, deploy, WithNeeds([]string{"unit_test"})))
+	p.AddStage(deploy)
+	return p
+}
+
+func selectedJobs(p *Pipeline) []string {
+	var names []string
+	for _, stage := range p.Stages {
+		for _, job := range stage.Jobs {
+			names = append(names, stage.Name+"/"+job.Name)
+		}
+	}
+	return names
+}
+
+func TestSelectChoosesStagesAndJobs(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  Selection
+		want []string
+	}{
+		{
+			name: "only glob and matrix name",
+			sel:  Selection{Only: []string{"*_test", "build_job"}},
+			want: []string{"build/build_job-Debug", "build/build_job-Release", "test/unit_test", "test/lint_test"},
+		},
+		{
+			name: "stage range",
+			sel:  Selection{FromStage: "test", UntilStage: "test"},
+			want: []string{"test/unit_test", "test/lint_test"},
+		},
+		{
+			name: "skip wins",
+			sel:  Selection{Only: []string{"build"}, Skip: []string{"*-Debug", "docs_job"}},
+			want: []string{"build/build_job-Release"},
+		},
+		{
+			name: "with needs",
+			sel:  Selection{FromStage: "deploy", WithNeeds: true},
+			want: []string{"build/build_job-Release", "test/unit_test", "deploy/deploy_job"},
+		},
+		{
+			name: "with needs respects skip",
+			sel:  Selection{Only: []string{"deploy_job"}, Skip: []string{"build"}, WithNeeds: true},
+			want: []string{"test/unit_test", "deploy/deploy_job"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newSelectionPipeline(t)
+			if err := p.Select(tt.sel); err != nil {
+				t.Fatalf("Select() error = %v", err)
+			}
+			if got := selectedJobs(p); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("selected jobs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectKeepsStagesWithoutJobsUnlessExcludedByName(t *testing.T) {
+	stageNames := func(p *Pipeline) []string {
+		var names []string
+		for _, stage := range p.Stages {
+			names = append(names, stage.Name)
+		}
+		return names
+	}
+	tests := []struct {
+		name string
+		sel  Selection
+		want []string
+	}{
+		{name: "no selection", sel: Selection{}, want: []string{"build", "test", "notify", "cleanup"}},
+		{name: "jobs filtered out", sel: Selection{Only: []string{"build_job"}}, want: []string{"build", "notify", "cleanup"}},
+		{name: "skipped by name", sel: Selection{Skip: []string{"notify", "cleanup"}}, want: []string{"build", "test"}},
+		{name: "outside stage range", sel: Selection{UntilStage: "test"}, want: []string{"build", "test"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()))
+			build := NewStage("build", p)
+			build.AddJob(NewJob("build_job", nil, build))
+			test := NewStage("test", p)
+			test.AddJob(NewJob("test_job", nil, test))
+			// notify 只有hooks，cleanup 的Job即使没有被选中，when: always 的Stage也要执行
+			notify := NewStage("notify", p, WithWhen(Always), WithStageHooks(&Hooks{Before: []*Action{NewAction(p.Shell, "true")}}))
+			cleanup := NewStage("cleanup", p, WithWhen(Always))
+			cleanup.AddJob(NewJob("cleanup_job", nil, cleanup))
+			p.AddStage(build).AddStage(test).AddStage(notify).AddStage(cleanup)
+
+			if err := p.Select(tt.sel); err != nil {
+				t.Fatalf("Select() error = %v", err)
+			}
+			if got := stageNames(p); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("stages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectRejectsInvalidSelections(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  Selection
+		want string
+	}{
+		{name: "unknown stage", sel: Selection{FromStage: "lint"}, want: `unknown stage "lint"`},
+		{name: "reversed range", sel: Selection{FromStage: "deploy", UntilStage: "build"}, want: "stage deploy comes after stage build"},
+		{name: "no match", sel: Selection{Only: []string{"package*"}}, want: `no stage or job matches "package*"`},
+		{name: "bad pattern", sel: Selection{Skip: []string{"[build"}}, want: `invalid pattern "[build"`},
+		{name: "nothing left", sel: Selection{Only: []string{"docs_job"}, Skip: []string{"build"}}, want: "no jobs selected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newSelectionPipeline(t)
+			if err := p.Select(tt.sel); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Select() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	empty := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()))
+	if err := empty.Select(Selection{}); err == nil || err.Error() != "no jobs selected" {
+		t.Fatalf("Select() on empty pipeline error = %v, want no jobs selected", err)
+	}
+}
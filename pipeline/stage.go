@@ -4,50 +4,196 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"os"
 	"sync"
+	"time"
 
 	"github.com/Meha555/go-pipeline/internal"
 )
 
-// Stage 定义阶段结构体
+// When Stage的执行条件，根据到目前为止的流水线状态决定是否执行该Stage
+type When int
+
+const (
+	OnSuccess When = iota // 之前的Stage全部成功（包括允许的失败）时执行
+	OnFailure             // 之前有Stage失败或超时时执行
+	Always                // 总是执行，包括流水线被取消或超时后
+)
+
+func (w When) String() string {
+	switch w {
+	case OnSuccess:
+		return "on_success"
+	case OnFailure:
+		return "on_failure"
+	case Always:
+		return "always"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseWhen 将 When.String 的结果转换回 When，空字符串表示默认的 OnSuccess
+func ParseWhen(name string) (When, error) {
+	if name == "" {
+		return OnSuccess, nil
+	}
+	for _, when := range []When{OnSuccess, OnFailure, Always} {
+		if when.String() == name {
+			return when, nil
+		}
+	}
+	return OnSuccess, fmt.Errorf("unknown when %q", name)
+}
+
+// match 判断流水线到目前为止的状态为status时是否应该执行Stage
+func (w When) match(status Status) bool {
+	switch w {
+	case OnSuccess:
+		return status.succeeded()
+	case OnFailure:
+		return status == Failed || status == TimedOut
+	case Always:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stage 定义阶段结构体。Stage只是定义，执行过程中的状态保存在 stageRun 中
 type Stage struct {
-	Name string
-	Jobs []*Job
+	Name  string
+	Jobs  []*Job
+	When  When
+	Hooks *Hooks
+	// FailFast 为nil时使用 Pipeline.FailFast
+	FailFast *bool
+	// MaxParallel 同时执行的Job的最大数量，不大于0时表示不限制
+	MaxParallel int
+	// Timeout Stage中所有Job的执行时间上限，不大于0时表示不限制
+	Timeout time.Duration
 
-	timer     *internal.Timer
-	wg        *sync.WaitGroup
-	p         *Pipeline
-	failedCnt int
-	logger    *slog.Logger
+	p      *Pipeline
+	logger *slog.Logger
+}
+
+type StageOptions func(*Stage)
+
+func WithWhen(when When) StageOptions {
+	return func(s *Stage) {
+		s.When = when
+	}
 }
 
-func NewStage(name string, p *Pipeline) *Stage {
-	return &Stage{
+func WithStageHooks(hooks *Hooks) StageOptions {
+	return func(s *Stage) {
+		s.Hooks = hooks
+	}
+}
+
+func WithStageFailFast(failFast bool) StageOptions {
+	return func(s *Stage) {
+		s.FailFast = &failFast
+	}
+}
+
+func WithMaxParallel(maxParallel int) StageOptions {
+	return func(s *Stage) {
+		s.MaxParallel = maxParallel
+	}
+}
+
+func WithStageTimeout(timeout time.Duration) StageOptions {
+	return func(s *Stage) {
+		s.Timeout = timeout
+	}
+}
+
+func NewStage(name string, p *Pipeline, opts ...StageOptions) *Stage {
+	s := &Stage{
 		Name:   name,
 		Jobs:   make([]*Job, 0),
+		When:   OnSuccess,
+		Hooks:  &Hooks{},
 		p:      p,
-		timer:  &internal.Timer{},
-		wg:     &sync.WaitGroup{},
 		logger: p.logger.With("stage", name),
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
-func (s *Stage) AddJob(job *Job) *Stage {
+func (s *Stage) AddJob(job *Job) error {
 	for _, existing := range s.Jobs {
 		if existing.Name == job.Name {
-			s.logger.Error(fmt.Sprintf("duplicate job %q in stage %s", job.Name, s.Name), "job", job.Name, "stage", s.Name)
-			os.Exit(1)
+			return fmt.Errorf("duplicate job %q in stage %s", job.Name, s.Name)
 		}
 	}
 	s.Jobs = append(s.Jobs, job)
-	return s
+	return nil
+}
+
+// failFast 判断Stage中有Job失败后是否立即取消其他Job
+func (s *Stage) failFast() bool {
+	if s.FailFast != nil {
+		return *s.FailFast
+	}
+	return s.p.FailFast
+}
+
+// stageRun Stage在流水线的一次执行中的状态
+type stageRun struct {
+	*Stage
+	r         *Run
+	jobs      []*jobRun
+	timer     *internal.Timer
+	wg        *sync.WaitGroup
+	failedCnt int
+	result    *StageResult
+	capacity  *capacity
+	logger    *slog.Logger
+
+	// abort 在fail fast或者Stage超时时被取消，Stage中仍在执行的Job随之被取消
+	abort     context.Context
+	abortJobs context.CancelCauseFunc
+	abortOnce sync.Once
+	// skipStatus Stage被跳过时的状态，流水线超时后剩余的Stage标记为 TimedOut
+	skipStatus Status
+	// deadline Stage超时的计时器，由 startDeadline 在Stage或者其中被提前调度的Job开始执行时启动
+	deadline     *time.Timer
+	deadlineOnce sync.Once
+}
+
+func newStageRun(s *Stage, r *Run) *stageRun {
+	sr := &stageRun{
+		Stage:      s,
+		r:          r,
+		jobs:       make([]*jobRun, 0, len(s.Jobs)),
+		timer:      &internal.Timer{},
+		wg:         &sync.WaitGroup{},
+		capacity:   newCapacity(s.MaxParallel),
+		logger:     s.logger.With("run_id", r.ID),
+		skipStatus: Skiped,
+	}
+	sr.abort, sr.abortJobs = context.WithCancelCause(context.Background())
+	for _, job := range s.Jobs {
+		sr.jobs = append(sr.jobs, newJobRun(job, sr))
+	}
+	return sr
 }
 
-func (s *Stage) Perform(ctx context.Context) (status Status) {
-	// Stage是串行执行的，所以这里不会对当前进程的环境变量表产生并发写入
-	os.Setenv("STAGE_NAME", s.Name)
+func (s *stageRun) perform(ctx context.Context) (status Status) {
 	status = Success
+	s.result = &StageResult{Name: s.Name, StartTime: time.Now()}
+	defer func() {
+		s.result.Status = status
+		s.result.EndTime = time.Now()
+		s.result.Duration = s.result.EndTime.Sub(s.result.StartTime)
+		s.result.Jobs = s.jobResults()
+		s.result.Error = timeoutMessage(s.jobs)
+	}()
 	if trace, ok := ctx.Value(internal.TraceKey).(bool); ok && trace {
 		s.timer.Start()
 		defer func() {
@@ -55,47 +201,138 @@ func (s *Stage) Perform(ctx context.Context) (status Status) {
 		}()
 	}
 
-	s.logger.Info(fmt.Sprintf("Stage@%s: %d jobs", s.Name, len(s.Jobs)), "jobs", len(s.Jobs))
-
-	if err := os.Chdir(s.p.Workdir); err != nil {
-		s.logger.Error(fmt.Sprintf("change workdir to %s failed: %v", s.p.Workdir, err), "error", err, "workdir", s.p.Workdir)
-		return Failed
-	}
+	s.logger.Info(fmt.Sprintf("Stage@%s: %d jobs", s.Name, len(s.jobs)), "jobs", len(s.jobs))
 
 	defer func() {
-		statistics := fmt.Sprintf("(%d failed/%d total)", s.failedCnt, len(s.Jobs))
-		if status == Failed {
-			s.logger.Error(fmt.Sprintf("Stage@%s failed %s", s.Name, statistics), "failed", s.failedCnt, "total", len(s.Jobs))
-		} else {
-			s.logger.Info(fmt.Sprintf("Stage@%s success %s", s.Name, statistics), "failed", s.failedCnt, "total", len(s.Jobs))
+		statistics := fmt.Sprintf("(%d failed/%d total)", s.failedCnt, len(s.jobs))
+		switch status {
+		case Failed:
+			s.logger.Error(fmt.Sprintf("Stage@%s failed %s", s.Name, statistics), "failed", s.failedCnt, "total", len(s.jobs))
+		case Canceled:
+			s.logger.Warn(fmt.Sprintf("Stage@%s canceled %s", s.Name, statistics), "failed", s.failedCnt, "total", len(s.jobs))
+		case TimedOut:
+			s.logger.Error(fmt.Sprintf("Stage@%s timed out %s", s.Name, statistics), "failed", s.failedCnt, "total", len(s.jobs))
+		case AllowedFailure:
+			s.logger.Warn(fmt.Sprintf("Stage@%s success with allowed failures %s", s.Name, statistics), "failed", s.failedCnt, "total", len(s.jobs))
+		default:
+			s.logger.Info(fmt.Sprintf("Stage@%s success %s", s.Name, statistics), "failed", s.failedCnt, "total", len(s.jobs))
 		}
 	}()
 
-	for _, job := range s.Jobs {
+	// Stage的hooks可以通过 STAGE_NAME 得知所属的Stage，结果hooks还可以通过 STAGE_STATUS 得知Stage的执行结果
+	hookEnv := []string{envLine("STAGE_NAME", s.Name)}
+	// 全部Job都沿用上一次执行的结果时，Stage的hooks也已经执行过了
+	hooks := s.Hooks
+	if s.restoredOnly() {
+		hooks = &Hooks{}
+	}
+	hooks.runBefore(ctx, s.logger, hookEnv)
+
+	// Stage超时后取消其中仍在执行或者等待执行的Job，包括被提前调度的Job
+	s.startDeadline()
+	defer s.stopDeadline()
+
+	for _, job := range s.jobs {
+		// 声明了needs的Job由Pipeline在其依赖完成后调度，这里只需要等待其结果。
+		// 有执行条件的Stage不会被提前调度，到这里才开始等待依赖
+		if job.Needs != nil {
+			if s.When != OnSuccess {
+				go job.doAfterNeeds(ctx, nil)
+			}
+			continue
+		}
 		s.wg.Add(1)
-		go job.Do(ctx)
+		go job.do(ctx)
 	}
 	// 收集结果
-	for _, job := range s.Jobs {
-		jobStatus := <-job.Result()
-		if jobStatus == Failed {
+	for _, job := range s.jobs {
+		jobStatus := <-job.resCh
+		if jobStatus.failed() {
 			s.failedCnt++
-			status = Failed
 		}
+		status = worseStatus(status, jobStatus)
 	}
 	// 等待所有任务完成
 	s.wg.Wait()
-	if status == Success {
-		for _, job := range s.Jobs {
-			if len(job.Exports) == 0 {
+	s.abortJobs(nil)
+	if msg := timeoutMessage(s.jobs); msg != "" {
+		s.logger.Error(fmt.Sprintf("Stage@%s: %s", s.Name, msg))
+	}
+	if status.succeeded() {
+		for _, job := range s.jobs {
+			if len(job.exported) == 0 {
 				continue
 			}
-			// 注入环境变量到当前进程
-			if err := job.importExports(); err != nil {
-				job.logger.Error(fmt.Sprintf("import exports failed: %v", err), "error", err)
-				return Failed
-			}
+			// 注入环境变量到本次执行的运行环境
+			job.importExports(s.r.env)
 		}
 	}
+	// 与Job的hooks一样，Stage超时或被取消时也要执行，因此不能继承已经结束的ctx
+	hooks.runAfter(context.WithoutCancel(ctx), s.logger, hookEnv)
+	hooks.runOutcome(context.WithoutCancel(ctx), s.logger, status, append(hookEnv, envLine("STAGE_STATUS", status.String())))
 	return
 }
+
+// startDeadline 开始计算Stage的超时时间，超时后取消Stage中的Job。
+// Stage开始执行时，或者其中声明了needs的Job被提前调度、在Stage开始之前就开始执行时调用，只有第一次调用有效
+func (s *stageRun) startDeadline() {
+	if s.Timeout <= 0 {
+		return
+	}
+	s.deadlineOnce.Do(func() {
+		timeout := &timeoutError{unit: "stage " + s.Name, timeout: s.Timeout}
+		s.deadline = time.AfterFunc(s.Timeout, func() { s.abortJobs(timeout) })
+	})
+}
+
+// stopDeadline 在Stage执行结束后停止计时
+func (s *stageRun) stopDeadline() {
+	// 保证之后不会再开始计时，同时保证读取deadline时已经完成赋值
+	s.deadlineOnce.Do(func() {})
+	if s.deadline != nil {
+		s.deadline.Stop()
+	}
+}
+
+// jobFailed 在Job失败后调用，开启fail fast时取消Stage中的其他Job
+func (s *stageRun) jobFailed(job *jobRun) {
+	if !s.failFast() {
+		return
+	}
+	s.abortOnce.Do(func() {
+		s.logger.Warn(fmt.Sprintf("Stage@%s fail fast: job %s failed, canceling other jobs", s.Name, job.Name), "job", job.Name)
+		s.abortJobs(fmt.Errorf("job %s failed", job.Name))
+	})
+}
+
+// skip 跳过不满足执行条件的Stage。已经被提前调度的Job照常执行，其余Job标记为skipStatus，依赖它们的Job也会被跳过
+func (s *stageRun) skip(status, skipStatus Status) {
+	s.skipStatus = skipStatus
+	s.logger.Info(fmt.Sprintf("Stage@%s skipped: when %s, pipeline %s", s.Name, s.When, status), "when", s.When.String(), "status", status.String())
+	// 先占用全部Job再逐个结束，否则结束一个Job时可能唤醒同一Stage中依赖它的Job
+	var skipped []*jobRun
+	for _, job := range s.jobs {
+		if job.claim() {
+			skipped = append(skipped, job)
+		}
+	}
+	for _, job := range skipped {
+		job.stageSkipped = true
+		status := skipStatus
+		job.finish(&status)
+		job.resCh <- status
+	}
+}
+
+func (s *stageRun) jobResults() []*JobResult {
+	results := make([]*JobResult, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		results = append(results, job.result.skippedResult())
+	}
+	return results
+}
+
+// skippedResult 返回没有被执行到的Stage的结果。其中声明了needs的Job可能已经被提前执行过了，会保留其真实结果
+func (s *stageRun) skippedResult() *StageResult {
+	return &StageResult{Name: s.Name, Status: s.skipStatus, Jobs: s.jobResults()}
+}
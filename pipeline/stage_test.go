@@ -1,25 +1,172 @@
 package pipeline
 
 import (
+	"context"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestStageAddJobRejectsDuplicateJobName(t *testing.T) {
-	if os.Getenv("TEST_DUPLICATE_JOB") == "1" {
-		p := NewPipeline("test", "1.0.0")
-		s := NewStage("build", p)
-		job1 := NewJob("compile", nil, s)
-		job2 := NewJob("compile", nil, s)
-		s.AddJob(job1)
-		s.AddJob(job2)
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestStageAddJobRejectsDuplicateJobName")
-	cmd.Env = append(os.Environ(), "TEST_DUPLICATE_JOB=1")
-	err := cmd.Run()
-	if err == nil {
-		t.Fatalf("expected process to exit with non-zero status")
+	p := mustNewPipeline(t, "test", "1.0.0")
+	s := NewStage("build", p)
+	if err := s.AddJob(NewJob("compile", nil, s)); err != nil {
+		t.Fatalf("AddJob() error = %v", err)
+	}
+	err := s.AddJob(NewJob("compile", nil, s))
+	if err == nil || !strings.Contains(err.Error(), `duplicate job "compile"`) {
+		t.Fatalf("AddJob() error = %v, want duplicate job error", err)
+	}
+	if len(s.Jobs) != 1 {
+		t.Fatalf("len(Jobs) = %d, want 1", len(s.Jobs))
+	}
+}
+
+func TestStagesRunAccordingToWhen(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	touch := func(name string) *Action { return NewAction(p.Shell, "touch "+name) }
+	addStage := func(name string, when When, jobs ...func(s *Stage) *Job) {
+		s := NewStage(name, p, WithWhen(when))
+		for _, job := range jobs {
+			s.AddJob(job(s))
+		}
+		p.AddStage(s)
+	}
+	addStage("build", OnSuccess, func(s *Stage) *Job {
+		return NewJob("build_job", []*Action{NewAction(p.Shell, "exit 1")}, s)
+	})
+	addStage("test", OnSuccess, func(s *Stage) *Job {
+		return NewJob("test_job", []*Action{touch("test")}, s)
+	})
+	addStage("deploy", OnSuccess, func(s *Stage) *Job {
+		return NewJob("deploy_job", []*Action{touch("deploy")}, s, WithNeeds([]string{"test_job"}))
+	})
+	addStage("notify", OnFailure, func(s *Stage) *Job {
+		return NewJob("notify_job", []*Action{touch("notify")}, s)
+	})
+	addStage("cleanup", Always, func(s *Stage) *Job {
+		return NewJob("cleanup_job", []*Action{touch("cleanup")}, s, WithNeeds([]string{"notify_job"}))
+	})
+	addStage("success", OnSuccess, func(s *Stage) *Job {
+		return NewJob("success_job", []*Action{touch("success")}, s)
+	})
+
+	result := p.Execute(context.Background())
+	if result.Status != Failed {
+		t.Fatalf("pipeline status = %s, want Failed", result.Status)
+	}
+	want := map[string]Status{"build": Failed, "test": Skiped, "deploy": Skiped, "notify": Success, "cleanup": Success, "success": Skiped}
+	for _, stage := range result.Stages {
+		if stage.Status != want[stage.Name] {
+			t.Errorf("stage %s status = %s, want %s", stage.Name, stage.Status, want[stage.Name])
+		}
+		_, err := os.Stat(filepath.Join(tmpDir, stage.Name))
+		if ran := err == nil; stage.Name != "build" && ran != (want[stage.Name] == Success) {
+			t.Errorf("stage %s ran = %v, want %v", stage.Name, ran, want[stage.Name] == Success)
+		}
+	}
+}
+
+func TestAlwaysStageRunsAfterCancellation(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "sleep 5")}, build))
+	p.AddStage(build)
+	cleanup := NewStage("cleanup", p, WithWhen(Always))
+	cleanup.AddJob(NewJob("cleanup_job", []*Action{NewAction(p.Shell, "touch cleaned")}, cleanup))
+	p.AddStage(cleanup)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(200*time.Millisecond, cancel)
+	result := p.Execute(ctx)
+	if result.Status != Canceled {
+		t.Fatalf("pipeline status = %s, want Canceled", result.Status)
+	}
+	if status := result.Stages[1].Status; status != Success {
+		t.Fatalf("cleanup stage status = %s, want Success", status)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "cleaned")); err != nil {
+		t.Fatalf("cleanup stage did not run: %v", err)
+	}
+}
+
+func TestFailFastCancelsSiblingJobs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithFailFast(true))
+	test := NewStage("test", p)
+	test.AddJob(NewJob("allowed_job", []*Action{NewAction(p.Shell, "exit 1")}, test, WithAllowFailure(true)))
+	test.AddJob(NewJob("slow_job", []*Action{NewAction(p.Shell, "sleep 10")}, test,
+		WithHooks(&Hooks{After: []*Action{NewAction(p.Shell, "touch slow.after")}})))
+	test.AddJob(NewJob("failing_job", []*Action{NewAction(p.Shell, "sleep 0.2; exit 1")}, test))
+	p.AddStage(test)
+	lint := NewStage("lint", p, WithWhen(Always), WithStageFailFast(false))
+	lint.AddJob(NewJob("failing_job", []*Action{NewAction(p.Shell, "exit 1")}, lint))
+	lint.AddJob(NewJob("short_job", []*Action{NewAction(p.Shell, "sleep 0.5; touch short.done")}, lint))
+	p.AddStage(lint)
+
+	start := time.Now()
+	result := p.Execute(context.Background())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("pipeline took %v, want sibling jobs canceled", elapsed)
+	}
+	if result.Status != Failed {
+		t.Fatalf("pipeline status = %s, want Failed", result.Status)
+	}
+	want := map[string]Status{"allowed_job": AllowedFailure, "slow_job": Canceled, "failing_job": Failed}
+	for _, job := range result.Stages[0].Jobs {
+		if job.Status != want[job.Name] {
+			t.Errorf("job %s status = %s, want %s", job.Name, job.Status, want[job.Name])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "slow.after")); err != nil {
+		t.Errorf("after hooks of canceled job did not run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "short.done")); err != nil {
+		t.Errorf("stage without fail fast canceled its jobs: %v", err)
+	}
+}
+
+func TestFailFastCancelsSiblingJobsWhenJobTimesOut(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(t.TempDir()), WithFailFast(true))
+	test := NewStage("test", p)
+	test.AddJob(NewJob("timeout_job", []*Action{NewAction(p.Shell, "sleep 3")}, test, WithTimeout(200*time.Millisecond)))
+	test.AddJob(NewJob("slow_job", []*Action{NewAction(p.Shell, "sleep 3")}, test))
+	p.AddStage(test)
+
+	start := time.Now()
+	result := p.Execute(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("pipeline took %v, want sibling jobs canceled", elapsed)
+	}
+	if result.Status != TimedOut {
+		t.Fatalf("pipeline status = %s, want TimedOut", result.Status)
+	}
+	want := map[string]Status{"timeout_job": TimedOut, "slow_job": Canceled}
+	for _, job := range result.Stages[0].Jobs {
+		if job.Status != want[job.Name] {
+			t.Errorf("job %s status = %s, want %s", job.Name, job.Status, want[job.Name])
+		}
 	}
 }
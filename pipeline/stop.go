@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultStopSignal      = "SIGTERM"
+	DefaultStopGracePeriod = 10 * time.Second
+)
+
+// StopPolicy 描述Job超时或者流水线被取消时如何停止Action：
+// 先向Action启动的整个进程树发送 Signal，超过 GracePeriod 仍未退出则强制杀死。
+// Windows上不支持信号，会直接结束整个进程树。
+type StopPolicy struct {
+	Signal      string
+	GracePeriod time.Duration
+}
+
+type stopPolicyKey struct{}
+
+func withStopPolicy(ctx context.Context, stop StopPolicy) context.Context {
+	return context.WithValue(ctx, stopPolicyKey{}, stop)
+}
+
+func stopPolicyFrom(ctx context.Context) StopPolicy {
+	if stop, ok := ctx.Value(stopPolicyKey{}).(StopPolicy); ok {
+		return stop
+	}
+	return StopPolicy{Signal: DefaultStopSignal, GracePeriod: DefaultStopGracePeriod}
+}
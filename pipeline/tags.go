@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Meha555/go-pipeline/parser"
+)
+
+// TagFilter 根据Job的tags选择要执行的Job，由 --tags 和 --exclude-tags 两个表达式组成。
+// 表达式由tag名、!（非）、&&（与）、||或者逗号（或）以及括号组成，例如 quick,lint 或者 unit && !windows
+type TagFilter struct {
+	include tagExpr // 为nil时选择全部Job
+	exclude tagExpr // 为nil时不排除任何Job
+}
+
+// ParseTagFilter 解析 --tags 和 --exclude-tags 表达式，空字符串表示不限制
+func ParseTagFilter(include, exclude string) (*TagFilter, error) {
+	f := &TagFilter{}
+	var err error
+	if f.include, err = parseTagExpr(include); err != nil {
+		return nil, fmt.Errorf("invalid tags %q: %w", include, err)
+	}
+	if f.exclude, err = parseTagExpr(exclude); err != nil {
+		return nil, fmt.Errorf("invalid exclude tags %q: %w", exclude, err)
+	}
+	return f, nil
+}
+
+// Match 判断带有tags的Job是否被选中
+func (f *TagFilter) Match(tags []string) bool {
+	if f == nil {
+		return true
+	}
+	if f.include != nil && !f.include.eval(tags) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.eval(tags)
+}
+
+type tagExpr interface {
+	eval(tags []string) bool
+}
+
+type tagName string
+
+func (t tagName) eval(tags []string) bool { return slices.Contains(tags, string(t)) }
+
+type tagNot struct{ x tagExpr }
+
+func (t tagNot) eval(tags []string) bool { return !t.x.eval(tags) }
+
+type tagAnd struct{ x, y tagExpr }
+
+func (t tagAnd) eval(tags []string) bool { return t.x.eval(tags) && t.y.eval(tags) }
+
+type tagOr struct{ x, y tagExpr }
+
+func (t tagOr) eval(tags []string) bool { return t.x.eval(tags) || t.y.eval(tags) }
+
+const tagOperators = "!(),&|"
+
+// tagParser 递归下降解析tag表达式，优先级从低到高依次为 ||（以及逗号）、&&、!
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseTagExpr(expr string) (tagExpr, error) {
+	tokens := tokenizeTags(expr)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &tagParser{tokens: tokens}
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return x, nil
+}
+
+func tokenizeTags(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		switch {
+		case expr[i] == ' ' || expr[i] == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case strings.IndexByte(tagOperators, expr[i]) >= 0:
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		default:
+			// tag名一直延续到空白或者运算符，是否合法在解析时检查
+			end := strings.IndexAny(expr[i:], tagOperators+" \t")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			tokens = append(tokens, expr[i:i+end])
+			i += end
+		}
+	}
+	return tokens
+}
+
+func (p *tagParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *tagParser) parseOr() (tagExpr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "||" || p.next() == "," {
+		p.pos++
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = tagOr{x, y}
+	}
+	return x, nil
+}
+
+func (p *tagParser) parseAnd() (tagExpr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "&&" {
+		p.pos++
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = tagAnd{x, y}
+	}
+	return x, nil
+}
+
+func (p *tagParser) parseUnary() (tagExpr, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "!":
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{x}, nil
+	case token == "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return x, nil
+	case parser.IsTagName(token):
+		p.pos++
+		return tagName(token), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+}
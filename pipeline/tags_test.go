@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagFilterMatch(t *testing.T) {
+	tests := []struct {
+		include, exclude string
+		tags             []string
+		want             bool
+	}{
+		{"", "", nil, true},
+		{"quick", "", []string{"quick", "lint"}, true},
+		{"quick", "", nil, false},
+		{"quick,lint", "", []string{"lint"}, true},
+		{"quick || lint", "", []string{"slow"}, false},
+		{"unit && !windows", "", []string{"unit"}, true},
+		{"unit && !windows", "", []string{"unit", "windows"}, false},
+		{"!(slow || flaky)", "", nil, true},
+		{"!(slow || flaky)", "", []string{"flaky"}, false},
+		{"a || b && c", "", []string{"a"}, true},
+		{"(a || b) && c", "", []string{"a"}, false},
+		{"", "slow", []string{"slow", "quick"}, false},
+		{"quick", "os:windows", []string{"quick", "os:linux"}, true},
+	}
+	for _, tt := range tests {
+		filter, err := ParseTagFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatalf("ParseTagFilter(%q, %q) error = %v", tt.include, tt.exclude, err)
+		}
+		if got := filter.Match(tt.tags); got != tt.want {
+			t.Errorf("ParseTagFilter(%q, %q).Match(%v) = %v, want %v", tt.include, tt.exclude, tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestParseTagFilterRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"quick &&", "(quick", "quick)", "quick & lint", "a b", "!"} {
+		if _, err := ParseTagFilter(expr, ""); err == nil || !strings.Contains(err.Error(), "invalid tags") {
+			t.Errorf("ParseTagFilter(%q) error = %v, want invalid tags", expr, err)
+		}
+	}
+	if _, err := ParseTagFilter("", "slow ||"); err == nil || !strings.Contains(err.Error(), "invalid exclude tags") {
+		t.Errorf("ParseTagFilter() error = %v, want invalid exclude tags", err)
+	}
+}
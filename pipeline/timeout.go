@@ -0,0 +1,55 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeoutError Pipeline或Stage的执行时间超过了timeout，作为ctx被取消的原因
+type timeoutError struct {
+	unit    string
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s timeout %v exceeded", e.unit, e.timeout)
+}
+
+// timeoutCause 返回ctx因为Pipeline或Stage超时而结束的原因，其他原因结束或者没有结束时返回nil
+func timeoutCause(ctx context.Context) *timeoutError {
+	var timeout *timeoutError
+	if errors.As(context.Cause(ctx), &timeout) {
+		return timeout
+	}
+	return nil
+}
+
+// interruptedStatus 返回因为ctx结束而被打断的工作的状态
+func interruptedStatus(ctx context.Context) Status {
+	if timeoutCause(ctx) != nil {
+		return TimedOut
+	}
+	return Canceled
+}
+
+// timeoutMessage 描述超时发生时正在执行的Job，没有Job因为超时被打断时返回空字符串
+func timeoutMessage(jobs []*jobRun) string {
+	var cause *timeoutError
+	var running []string
+	for _, job := range jobs {
+		if job.timedOut == nil {
+			continue
+		}
+		if cause == nil {
+			cause = job.timedOut
+		}
+		running = append(running, job.Name)
+	}
+	if cause == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v while running %s", cause, strings.Join(running, ", "))
+}
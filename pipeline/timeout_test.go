@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineTimeoutMarksRemainingWorkTimedOut(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir), WithPipelineTimeout(300*time.Millisecond))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("quick_job", []*Action{NewAction(p.Shell, "true")}, build))
+	build.AddJob(NewJob("slow_job", []*Action{NewAction(p.Shell, "sleep 10")}, build, WithAllowFailure(true)))
+	p.AddStage(build)
+	test := NewStage("test", p)
+	test.AddJob(NewJob("test_job", []*Action{NewAction(p.Shell, "true")}, test))
+	p.AddStage(test)
+	cleanup := NewStage("cleanup", p, WithWhen(OnFailure))
+	cleanup.AddJob(NewJob("cleanup_job", []*Action{NewAction(p.Shell, "touch cleaned")}, cleanup))
+	p.AddStage(cleanup)
+
+	start := time.Now()
+	result := p.Execute(context.Background())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("pipeline took %v, want it stopped by the timeout", elapsed)
+	}
+	if result.Status != TimedOut {
+		t.Fatalf("pipeline status = %s, want TimedOut", result.Status)
+	}
+	if want := "pipeline timeout 300ms exceeded while running slow_job"; result.Error != want {
+		t.Fatalf("pipeline error = %q, want %q", result.Error, want)
+	}
+	want := map[string]Status{"quick_job": Success, "slow_job": TimedOut, "test_job": TimedOut, "cleanup_job": Success}
+	for _, stage := range result.Stages {
+		for _, job := range stage.Jobs {
+			if job.Status != want[job.Name] {
+				t.Errorf("job %s status = %s, want %s", job.Name, job.Status, want[job.Name])
+			}
+		}
+	}
+	if status := result.Stages[1].Status; status != TimedOut {
+		t.Errorf("test stage status = %s, want TimedOut", status)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "cleaned")); err != nil {
+		t.Errorf("on_failure stage did not run after timeout: %v", err)
+	}
+}
+
+func TestStageTimeoutCancelsItsJobs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "true")}, build))
+	p.AddStage(build)
+	test := NewStage("test", p, WithStageTimeout(300*time.Millisecond))
+	test.AddJob(NewJob("slow_job", []*Action{NewAction(p.Shell, "sleep 10")}, test, WithNeeds([]string{"build_job"})))
+	test.AddJob(NewJob("quick_job", []*Action{NewAction(p.Shell, "true")}, test))
+	p.AddStage(test)
+	deploy := NewStage("deploy", p)
+	deploy.AddJob(NewJob("deploy_job", []*Action{NewAction(p.Shell, "true")}, deploy))
+	p.AddStage(deploy)
+
+	start := time.Now()
+	result := p.Execute(context.Background())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("pipeline took %v, want it stopped by the stage timeout", elapsed)
+	}
+	if result.Status != TimedOut {
+		t.Fatalf("pipeline status = %s, want TimedOut", result.Status)
+	}
+	stage := result.Stages[1]
+	if stage.Status != TimedOut || !strings.Contains(stage.Error, "stage test timeout 300ms exceeded while running slow_job") {
+		t.Fatalf("test stage = %s (%q), want TimedOut while running slow_job", stage.Status, stage.Error)
+	}
+	// 只有流水线本身超时时剩余的Stage才标记为超时
+	if status := result.Stages[2].Status; status != Skiped {
+		t.Errorf("deploy stage status = %s, want Skiped", status)
+	}
+}
+
+func TestStageTimeoutStartsWithEarlyScheduledJob(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tmpDir := t.TempDir()
+	p := mustNewPipeline(t, "test", "1.0.0", WithShell("sh"), WithWorkdir(tmpDir))
+	build := NewStage("build", p)
+	build.AddJob(NewJob("build_job", []*Action{NewAction(p.Shell, "true")}, build))
+	build.AddJob(NewJob("docs_job", []*Action{NewAction(p.Shell, "sleep 2")}, build))
+	p.AddStage(build)
+	// slow_job 在build阶段还没有结束时就开始执行，Stage的超时应当从它开始执行时算起
+	test := NewStage("test", p, WithStageTimeout(300*time.Millisecond))
+	test.AddJob(NewJob("slow_job", []*Action{NewAction(p.Shell, "sleep 10")}, test, WithNeeds([]string{"build_job"})))
+	p.AddStage(test)
+
+	result := p.Execute(context.Background())
+	if result.Status != TimedOut {
+		t.Fatalf("pipeline status = %s, want TimedOut", result.Status)
+	}
+	job := result.Stages[1].Jobs[0]
+	if job.Status != TimedOut {
+		t.Fatalf("slow_job status = %s, want TimedOut", job.Status)
+	}
+	if job.Duration > time.Second {
+		t.Fatalf("slow_job ran for %v, want it stopped 300ms after it started", job.Duration)
+	}
+}
@@ -0,0 +1,14 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+func WriteJSON(w io.Writer, result *pipeline.RunResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
@@ -0,0 +1,171 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+// JUnit的格式没有统一的标准，这里按照Jenkins和GitLab都能识别的常见格式输出：
+// 流水线对应testsuites，Stage对应testsuite，Job对应testcase
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func WriteJUnit(w io.Writer, result *pipeline.RunResult) error {
+	suites := junitTestSuites{
+		Name: fmt.Sprintf("%s@%s", result.Name, result.Version),
+		Time: seconds(result.Duration),
+	}
+	for _, stage := range result.Stages {
+		suite := junitTestSuite{
+			Name: stage.Name,
+			Time: seconds(stage.Duration),
+		}
+		if !stage.StartTime.IsZero() {
+			suite.Timestamp = stage.StartTime.Format("2006-01-02T15:04:05")
+		}
+		for _, job := range stage.Jobs {
+			testCase := junitCase(result, stage, job)
+			switch {
+			case testCase.Failure != nil:
+				suite.Failures++
+			case testCase.Error != nil:
+				suite.Errors++
+			case testCase.Skipped != nil:
+				suite.Skipped++
+			}
+			suite.Tests++
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitCase(result *pipeline.RunResult, stage *pipeline.StageResult, job *pipeline.JobResult) junitTestCase {
+	testCase := junitTestCase{
+		Name:      job.Name,
+		ClassName: fmt.Sprintf("%s.%s", result.Name, stage.Name),
+		Time:      seconds(job.Duration),
+	}
+	if output := jobOutput(job); output != "" {
+		testCase.SystemOut = &junitOutput{Text: xmlText(output)}
+	}
+	action := failedAction(job)
+	switch {
+	case allowedFailure(job):
+		testCase.Skipped = &junitMessage{Message: fmt.Sprintf("allowed failure: %s", actionMessage(action))}
+	case job.Status == pipeline.Failed:
+		message := "job failed"
+		if action != nil {
+			message = actionMessage(action)
+		}
+		testCase.Failure = &junitMessage{Message: message, Type: job.Status.String()}
+	case job.Status == pipeline.Canceled:
+		testCase.Error = &junitMessage{Message: "job canceled", Type: job.Status.String()}
+	case job.Status == pipeline.TimedOut:
+		testCase.Error = &junitMessage{Message: "job timed out", Type: job.Status.String()}
+	case job.Status == pipeline.Skiped:
+		testCase.Skipped = &junitMessage{Message: "job skipped"}
+	}
+	return testCase
+}
+
+func actionMessage(action *pipeline.ActionResult) string {
+	return fmt.Sprintf("action (%s) exited with code %d", strings.TrimSpace(action.Command), action.ExitCode)
+}
+
+// jobOutput 将Job中各个Action的输出按照执行顺序拼接起来
+func jobOutput(job *pipeline.JobResult) string {
+	var b strings.Builder
+	for _, action := range job.Actions {
+		if action.Status == pipeline.Skiped {
+			continue
+		}
+		fmt.Fprintf(&b, "$ %s\n", strings.TrimSpace(action.Command))
+		b.WriteString(action.Output)
+		if action.Output != "" && !strings.HasSuffix(action.Output, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// ansiEscape 匹配终端颜色等控制序列，CI中命令的输出经常带有这些序列
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]`)
+
+// xmlText 去掉输出中的终端控制序列，并将XML 1.0不允许的字符替换为U+FFFD。
+// CDATA中的内容不会被encoding/xml转义，包含这些字符时Jenkins和GitLab都无法解析报告
+func xmlText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' ||
+			r >= 0x20 && r <= 0xD7FF || r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF {
+			return r
+		}
+		return utf8.RuneError
+	}, ansiEscape.ReplaceAllString(s, ""))
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
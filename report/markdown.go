@@ -0,0 +1,56 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+func WriteMarkdown(w io.Writer, result *pipeline.RunResult) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s@%s: %s\n\n", result.Name, result.Version, result.Status)
+	if result.RunID != "" {
+		fmt.Fprintf(&b, "Run `%s`. ", result.RunID)
+	}
+	if !result.StartTime.IsZero() {
+		fmt.Fprintf(&b, "Started at %s, took %s.", result.StartTime.Format(time.RFC3339), result.Duration.Round(time.Millisecond))
+	}
+	b.WriteString("\n\n")
+	if result.Error != "" {
+		fmt.Fprintf(&b, "> %s\n\n", result.Error)
+	}
+	for _, stage := range result.Stages {
+		fmt.Fprintf(&b, "## %s: %s\n\n", stage.Name, stage.Status)
+		if stage.Error != "" {
+			fmt.Fprintf(&b, "> %s\n\n", stage.Error)
+		}
+		if len(stage.Jobs) == 0 {
+			b.WriteString("No jobs.\n\n")
+			continue
+		}
+		b.WriteString("| Job | Status | Duration | Details |\n")
+		b.WriteString("| --- | --- | --- | --- |\n")
+		for _, job := range stage.Jobs {
+			status := job.Status.String()
+			if allowedFailure(job) {
+				status = "Allowed failure"
+			}
+			details := ""
+			if action := failedAction(job); action != nil {
+				details = actionMessage(action)
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", escapeCell(job.Name), status, job.Duration.Round(time.Millisecond), escapeCell(details))
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func escapeCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
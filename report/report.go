@@ -0,0 +1,79 @@
+// Package report 将流水线的执行结果转换为CI系统和看板可以直接使用的报告格式
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatJUnit    = "junit"
+	FormatMarkdown = "markdown"
+)
+
+// Write 按照format将执行结果写入w
+func Write(w io.Writer, format string, result *pipeline.RunResult) error {
+	switch format {
+	case FormatJSON:
+		return WriteJSON(w, result)
+	case FormatJUnit:
+		return WriteJUnit(w, result)
+	case FormatMarkdown:
+		return WriteMarkdown(w, result)
+	default:
+		return fmt.Errorf("invalid report format %q: supported values are json, junit, markdown", format)
+	}
+}
+
+// WriteFile 将执行结果写入path，format为空时根据文件扩展名推断
+func WriteFile(path, format string, result *pipeline.RunResult) error {
+	if format == "" {
+		format = FormatFromPath(path)
+	}
+	// 先检查格式，避免因为格式错误留下一个空文件
+	if err := Write(io.Discard, format, result); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create report %s: %w", path, err)
+	}
+	defer file.Close()
+	if err := Write(file, format, result); err != nil {
+		return fmt.Errorf("write report %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// FormatFromPath 根据文件扩展名推断报告格式：.xml为junit，.md为markdown，其余为json
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		return FormatJUnit
+	case ".md", ".markdown":
+		return FormatMarkdown
+	default:
+		return FormatJSON
+	}
+}
+
+// failedAction 返回Job中第一个失败的Action
+func failedAction(job *pipeline.JobResult) *pipeline.ActionResult {
+	for _, action := range job.Actions {
+		if action.Status == pipeline.Failed || action.Status == pipeline.Canceled || action.Status == pipeline.TimedOut {
+			return action
+		}
+	}
+	return nil
+}
+
+// allowedFailure 判断Job是否是失败了但被allow_failure允许的情况
+func allowedFailure(job *pipeline.JobResult) bool {
+	return job.Status == pipeline.AllowedFailure
+}
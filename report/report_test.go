@@ -0,0 +1,149 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Meha555/go-pipeline/pipeline"
+)
+
+func testResult() *pipeline.RunResult {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &pipeline.RunResult{
+		Name:      "demo",
+		Version:   "1.0",
+		Status:    pipeline.Failed,
+		StartTime: start,
+		Duration:  3 * time.Second,
+		Stages: []*pipeline.StageResult{
+			{
+				Name:      "build",
+				Status:    pipeline.Failed,
+				StartTime: start,
+				Duration:  2 * time.Second,
+				Jobs: []*pipeline.JobResult{
+					{
+						Name:     "compile",
+						Status:   pipeline.Success,
+						Duration: time.Second,
+						Actions:  []*pipeline.ActionResult{{Command: "make", Status: pipeline.Success, Output: "built\n"}},
+					},
+					{
+						Name:     "unit",
+						Status:   pipeline.Failed,
+						Duration: time.Second,
+						Actions: []*pipeline.ActionResult{
+							{Command: "go test ./...", Status: pipeline.Failed, ExitCode: 2, Output: "FAIL"},
+							{Command: "echo done", Status: pipeline.Skiped},
+						},
+					},
+					{
+						Name:         "lint",
+						Status:       pipeline.AllowedFailure,
+						AllowFailure: true,
+						Actions:      []*pipeline.ActionResult{{Command: "golint", Status: pipeline.Failed, ExitCode: 1}},
+					},
+				},
+			},
+			{
+				Name:   "deploy",
+				Status: pipeline.Skiped,
+				Jobs:   []*pipeline.JobResult{{Name: "upload", Status: pipeline.Skiped}},
+			},
+		},
+	}
+}
+
+func TestWriteJUnitMapsStagesAndJobs(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJUnit, testResult()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("unmarshal junit report: %v\n%s", err, buf.String())
+	}
+	if suites.Tests != 4 || suites.Failures != 1 || suites.Skipped != 2 {
+		t.Fatalf("unexpected totals: tests=%d failures=%d skipped=%d", suites.Tests, suites.Failures, suites.Skipped)
+	}
+	if len(suites.Suites) != 2 || suites.Suites[0].Name != "build" || suites.Suites[1].Name != "deploy" {
+		t.Fatalf("unexpected testsuites: %+v", suites.Suites)
+	}
+
+	cases := suites.Suites[0].Cases
+	if cases[0].Failure != nil || cases[0].Skipped != nil || cases[0].SystemOut == nil || cases[0].SystemOut.Text != "$ make\nbuilt\n" {
+		t.Fatalf("unexpected success testcase: %+v", cases[0])
+	}
+	if cases[1].Failure == nil || !strings.Contains(cases[1].Failure.Message, "go test ./...") || !strings.Contains(cases[1].Failure.Message, "code 2") {
+		t.Fatalf("unexpected failed testcase: %+v", cases[1])
+	}
+	if cases[1].SystemOut == nil || cases[1].SystemOut.Text != "$ go test ./...\nFAIL\n" {
+		t.Fatalf("failed testcase output = %+v", cases[1].SystemOut)
+	}
+	if cases[2].Skipped == nil || !strings.HasPrefix(cases[2].Skipped.Message, "allowed failure") {
+		t.Fatalf("unexpected allowed failure testcase: %+v", cases[2])
+	}
+	if cases[0].ClassName != "demo.build" {
+		t.Fatalf("classname = %q", cases[0].ClassName)
+	}
+	if skipped := suites.Suites[1].Cases[0]; skipped.Skipped == nil {
+		t.Fatalf("unexpected skipped testcase: %+v", skipped)
+	}
+}
+
+func TestWriteJUnitStripsCharactersXMLDoesNotAllow(t *testing.T) {
+	result := testResult()
+	result.Stages[0].Jobs[0].Actions[0].Output = "\x1b[32mok\x1b[0m\tbuilt\x00 \x1b\xff]]>\n"
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJUnit, result); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("unmarshal junit report: %v\n%s", err, buf.String())
+	}
+	if got, want := suites.Suites[0].Cases[0].SystemOut.Text, "$ make\nok\tbuilt\uFFFD \uFFFD\uFFFD]]>\n"; got != want {
+		t.Fatalf("system-out = %q, want %q", got, want)
+	}
+}
+
+func TestWriteJSONAndMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJSON, testResult()); err != nil {
+		t.Fatalf("Write(json) error = %v", err)
+	}
+	var decoded pipeline.RunResult
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("unmarshal json report: %v", err)
+	}
+	if decoded.Status != pipeline.Failed || decoded.Stages[0].Jobs[1].Actions[0].ExitCode != 2 {
+		t.Fatalf("unexpected json report: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatMarkdown, testResult()); err != nil {
+		t.Fatalf("Write(markdown) error = %v", err)
+	}
+	for _, want := range []string{"# demo@1.0: Failed", "## build: Failed", "| unit | Failed | 1s | action (go test ./...) exited with code 2 |", "| lint | Allowed failure |"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("markdown report does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestFormatFromPathAndInvalidFormat(t *testing.T) {
+	for path, want := range map[string]string{"out.json": FormatJSON, "junit.XML": FormatJUnit, "summary.md": FormatMarkdown, "report": FormatJSON} {
+		if got := FormatFromPath(path); got != want {
+			t.Fatalf("FormatFromPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if err := Write(&bytes.Buffer{}, "yaml", testResult()); err == nil {
+		t.Fatal("Write() with invalid format should fail")
+	}
+}